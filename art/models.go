@@ -4,15 +4,30 @@ package art
 // Technique represents a atomic red team yaml technique file. Each technique
 // can contain multiple atomic tests which test a particular ttp.
 type Technique struct {
-	ID          string  `yaml:"attack_technique"`
-	DisplayName string  `yaml:"display_name"`
-	AtomicTests []*Test `yaml:"atomic_tests"`
-	Path        string  `yaml:"-"`
+	ID          string      `yaml:"attack_technique"`
+	DisplayName string      `yaml:"display_name"`
+	AtomicTests []*Test     `yaml:"atomic_tests"`
+	Path        string      `yaml:"-"`
+	Attack      *AttackInfo `yaml:"-"` // set when techniques are enriched with MITRE ATT&CK data
+}
+
+// AttackInfo holds the MITRE ATT&CK details of a technique. Tactics are the kill chain
+// phase names used by ATT&CK, for example credential-access.
+type AttackInfo struct {
+	Name            string
+	Tactics         []string
+	ParentTechnique string
+	SubTechniques   []string
+	DataSources     []string
+	Deprecated      bool
+	Revoked         bool
 }
 
 // A Test represents an atomic red team test for a specific technique.
 type Test struct {
 	TechniqueID            string `yaml:"-"` // ignore field
+	AtomicsFolder          string `yaml:"-"` // folder the test was loaded from
+	Path                   string `yaml:"-"` // technique file the test was loaded from
 	Name                   string
 	AutoGeneratedGUID      string `yaml:"auto_generated_guid"`
 	Description            string
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ejohn/go-atomic/runner"
+)
+
+// runGUIDs implements the guids command which adds an auto_generated_guid to the tests
+// that do not have one.
+func runGUIDs(arguments []string) int {
+	fs := flag.NewFlagSet("guids", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s guids -path <atomics folder> [-dry-run]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	folder := fs.String("path", "", "path to the atomics folder to update")
+	dryRun := fs.Bool("dry-run", false, "show the guids that would be added without changing any file")
+	_ = fs.Parse(arguments)
+	if *folder == "" {
+		fmt.Fprintf(os.Stderr, "path to atomics folder is required\n\n")
+		fs.Usage()
+		return 1
+	}
+
+	updates, err := runner.GenerateGUIDs(*folder, !*dryRun)
+	for _, update := range updates {
+		fmt.Printf("%s:%d: %s %s %q\n", update.Path, update.Line, update.GUID, update.TechniqueID, update.TestName)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
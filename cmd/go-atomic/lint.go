@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ejohn/go-atomic/runner"
+)
+
+// runLint implements the lint command. It prints the problems found in the atomics and
+// returns a non-zero exit code when any of them is an error.
+func runLint(arguments []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s lint -path <atomics folder> [-overlay <folder>] [-format json|text]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	folder := fs.String("path", "", "path to atomics folder or a .zip or .tar.gz release archive of atomic red team")
+	format := fs.String("format", "json", "output format, json or text")
+	var overlays args
+	fs.Var(&overlays, "overlay", "path to an atomics folder or archive loaded on top of -path, "+
+		"set multiple times in precedence order")
+	_ = fs.Parse(arguments)
+	if *folder == "" {
+		fmt.Fprintf(os.Stderr, "path to atomics folder is required\n\n")
+		fs.Usage()
+		return 1
+	}
+	if *format != "json" && *format != "text" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, use json or text\n", *format)
+		return 1
+	}
+
+	ar := &runner.Runner{
+		AtomicsFolder:  *folder,
+		OverlayFolders: overlays,
+	}
+	defer ar.Close()
+	report, err := ar.LoadTechniques()
+	var findings []runner.LintFinding
+	if report != nil {
+		findings = report.LintFindings()
+	}
+	if err != nil && len(findings) == 0 {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	findings = append(findings, ar.Lint()...)
+
+	result := struct {
+		Findings []runner.LintFinding
+		Errors   int
+		Warnings int
+	}{Findings: findings}
+	for _, finding := range findings {
+		if finding.Severity == runner.SeverityError {
+			result.Errors++
+		} else {
+			result.Warnings++
+		}
+	}
+	if *format == "json" {
+		dumpJSON(result)
+	} else {
+		for _, finding := range findings {
+			pos := finding.Path
+			if finding.Line > 0 {
+				pos = fmt.Sprintf("%s:%d:%d", pos, finding.Line, finding.Column)
+			}
+			if finding.TestNumber > 0 {
+				pos = fmt.Sprintf("%s: test %d %q", pos, finding.TestNumber, finding.TestName)
+			}
+			fmt.Printf("%s: %s: %s: %s\n", pos, finding.Severity, finding.Rule, finding.Message)
+		}
+		fmt.Printf("%d errors, %d warnings\n", result.Errors, result.Warnings)
+	}
+	if result.Errors > 0 {
+		return 1
+	}
+	return 0
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -21,6 +25,14 @@ import (
 var logger *log.Logger
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "guids":
+			os.Exit(runGUIDs(os.Args[2:]))
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		}
+	}
 	opts, err := processFlags()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
@@ -53,6 +65,58 @@ func validateOptions(f *options) error {
 		return fmt.Errorf("-dry-run and options like -test or -dependency cannot be specified at the same time")
 	}
 
+	if f.tactic != "" && f.attackBundle == "" {
+		return fmt.Errorf("-tactic requires an ATT&CK bundle set with -attack")
+	}
+
+	if f.tactic != "" && (f.guid != "" || f.number != "" || f.testName != "") {
+		return fmt.Errorf("-tactic cannot be used to select a single test with -guid, -num or -name")
+	}
+
+	if f.maxOutput < 0 {
+		return fmt.Errorf("-max-output cannot be negative")
+	}
+
+	if f.gracePeriod < 0 {
+		return fmt.Errorf("-grace-period cannot be negative")
+	}
+
+	if f.spillFolder != "" && f.maxOutput == 0 {
+		return fmt.Errorf("-spill-dir requires -max-output")
+	}
+
+	if f.parallel < 1 {
+		return fmt.Errorf("-parallel must be at least 1")
+	}
+
+	if !f.sandbox && (f.sandboxFolder != "" || f.keepSandbox) {
+		return fmt.Errorf("-sandbox-dir and -keep-sandbox require -sandbox")
+	}
+
+	if f.limits.CPUTime < 0 {
+		return fmt.Errorf("-limit-cpu cannot be negative")
+	}
+
+	if !f.cgroup && f.cgroupParent != "" {
+		return fmt.Errorf("-cgroup-parent requires -cgroup")
+	}
+
+	if f.ssh == "" && (f.sshKey != "" || f.sshKnownHosts != "" || f.sshFolder != "") {
+		return fmt.Errorf("-ssh-key, -ssh-known-hosts and -ssh-dir require -ssh")
+	}
+
+	if f.manual && f.parallel > 1 {
+		return fmt.Errorf("-manual cannot be used with -parallel, use -manual-results instead")
+	}
+
+	if f.manual && f.manualResults != "" {
+		return fmt.Errorf("-manual and -manual-results cannot be specified at the same time")
+	}
+
+	if f.list && (f.isRun || f.dryRun) {
+		return fmt.Errorf("-list cannot be used with -dry-run or options that run tests")
+	}
+
 	return nil
 }
 
@@ -70,25 +134,61 @@ func run(f *options) int {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return 1
 	}
+	// prompts go to stderr so that the results printed on stdout stay valid json
+	if f.manual {
+		f.stepConfirmer = &runner.InteractiveConfirmer{In: os.Stdin, Out: os.Stderr}
+	}
+	if f.manualResults != "" {
+		results, err := runner.LoadStepResults(f.manualResults)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		f.stepConfirmer = results
+	}
+	if f.ssh != "" && f.isRun {
+		target, err := dialTarget(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		defer target.Client.Close()
+		f.target = target
+	}
 
 	ar := &runner.Runner{
-		AtomicsFolder: f.atomicsFolder,
-	}
+		AtomicsFolder:  f.atomicsFolder,
+		OverlayFolders: f.overlays,
+		StrictLoad:     f.strict,
+		CacheFile:      f.cacheFile,
+		EnableCache:    !f.noCache,
+		AttackBundle:   f.attackBundle,
+	}
+	defer ar.Close()
 	// set debug logger
 	if f.debug {
 		ar.Logger = log.New(os.Stdout, "", log.LstdFlags)
 	}
 
-	err = ar.LoadTechniques()
+	report, err := ar.LoadTechniques()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return 1
 	}
+	if f.debug && report != nil {
+		for _, msg := range getErrorMessages(report.Err()) {
+			ar.Logger.Printf("%s\n", msg)
+		}
+	}
 
 	var techniqueIDs []string
 	if f.techniqueID != "" {
 		techniqueIDs = strings.Split(f.techniqueID, ",")
 	}
+	if err := runner.ValidateTechniquePatterns(techniqueIDs); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
 	var guids []string
 	if f.guid != "" {
 		guids = strings.Split(f.guid, ",")
@@ -101,6 +201,26 @@ func run(f *options) int {
 	}
 
 	if len(guids) > 0 {
+		var tests []*art.Test
+		var missing []error
+		for _, guid := range guids {
+			at, err := ar.GetTestByGUID(strings.TrimSpace(guid))
+			if err != nil {
+				missing = append(missing, err)
+				continue
+			}
+			tests = append(tests, at)
+		}
+		if !checkArguments(ar, tests, testArguments, f) {
+			return 1
+		}
+		if f.isRun && f.parallel > 1 {
+			for _, err := range missing {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+			runTests(ar, tests, testArguments, f)
+			return 0
+		}
 		for _, guid := range guids {
 			handleGUID(ar, testArguments, guid, f)
 		}
@@ -134,23 +254,107 @@ func run(f *options) int {
 }
 
 func handleFilterTests(techniqueIDs []string, ar *runner.Runner, testArguments map[string]string, options *options) int {
+	var tactics []string
+	if options.tactic != "" {
+		tactics = strings.Split(options.tactic, ",")
+	}
 	fc := &runner.FilterConfig{
 		Platform:      "",
 		Techniques:    techniqueIDs,
 		IncludeManual: true,
+		Tactics:       tactics,
 	}
 	filtered := ar.Filter(fc)
 	if len(filtered) == 0 {
-		fmt.Fprintf(os.Stderr, "no tests found matching criteria os: %s, techniques: %v\n",
-			runtime.GOOS, techniqueIDs)
+		fmt.Fprintf(os.Stderr, "no tests found matching criteria os: %s, techniques: %v, tactics: %v\n",
+			runtime.GOOS, techniqueIDs, tactics)
+		return 1
+	}
+	if options.list {
+		listTests(ar, filtered)
+		return 0
+	}
+	var tests []*art.Test
+	for _, tech := range filtered {
+		tests = append(tests, tech.AtomicTests...)
+	}
+	if !checkArguments(ar, tests, testArguments, options) {
 		return 1
 	}
+	if options.isRun && options.parallel > 1 {
+		runTests(ar, tests, testArguments, options)
+		return 0
+	}
 	for _, tech := range filtered {
 		runTechnique(ar, tech, testArguments, options)
 	}
 	return 0
 }
 
+// checkArguments builds every selected test with the supplied arguments so that unknown
+// or invalid arguments are reported before anything is run. When several tests are selected,
+// each test only gets the arguments it declares and a name is unknown only when none of the
+// tests declare it. It returns false if there were any problems.
+func checkArguments(ar *runner.Runner, tests []*art.Test, testArguments map[string]string, options *options) bool {
+	if testArguments == nil || !(options.isRun || options.dryRun) {
+		return true
+	}
+	ok := true
+	declared := make(map[string]bool)
+	for _, test := range tests {
+		for name := range test.InputArguments {
+			declared[name] = true
+		}
+		args := testArguments
+		if len(tests) > 1 {
+			args = declaredArguments(test, testArguments)
+		}
+		_, err := ar.BuildTest(test, args)
+		var argErr *runner.ArgumentError
+		if errors.As(err, &argErr) {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", test.TechniqueID, test.Name)
+			for _, problem := range argErr.Problems {
+				fmt.Fprintf(os.Stderr, "\t%s\n", problem)
+			}
+			ok = false
+		}
+	}
+	if len(tests) < 2 {
+		return ok
+	}
+	var unknown []string
+	for name := range testArguments {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		fmt.Fprintf(os.Stderr, "%s\n", runner.ArgumentProblem{
+			Kind:    runner.UnknownArgument,
+			Name:    name,
+			Value:   testArguments[name],
+			Message: "not an input argument of any selected test",
+		})
+		ok = false
+	}
+	return ok
+}
+
+// declaredArguments returns the supplied arguments that are input arguments of the test.
+func declaredArguments(test *art.Test, testArguments map[string]string) map[string]string {
+	if testArguments == nil {
+		return nil
+	}
+	args := make(map[string]string)
+	for name, value := range testArguments {
+		if _, found := test.InputArguments[name]; found {
+			args[name] = value
+		}
+	}
+	return args
+}
+
 func handleGUID(ar *runner.Runner, testArguments map[string]string, guid string, options *options) int {
 	at, err := ar.GetTestByGUID(strings.TrimSpace(guid))
 	if err != nil {
@@ -166,6 +370,9 @@ func handleTechName(ar *runner.Runner, testArguments map[string]string, options
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return 1
 	}
+	if !checkArguments(ar, []*art.Test{at}, testArguments, options) {
+		return 1
+	}
 	return runTest(ar, at, testArguments, options)
 }
 
@@ -180,6 +387,9 @@ func handleTechNumber(ar *runner.Runner, testArguments map[string]string, option
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return 1
 	}
+	if !checkArguments(ar, []*art.Test{at}, testArguments, options) {
+		return 1
+	}
 	return runTest(ar, at, testArguments, options)
 }
 
@@ -210,24 +420,90 @@ type options struct {
 	runDependency  bool
 	runAll         bool
 
-	isRun bool
-	debug bool
+	isRun   bool
+	debug   bool
+	strict  bool
+	noCache bool
+	list    bool
+
+	cacheFile     string
+	attackBundle  string
+	tactic        string
+	manual        bool
+	manualResults string
+	stream        bool
+	parallel      int
+	maxOutput     int64
+	spillFolder   string
 
 	arguments args
-
-	parsedTimeout *time.Duration
+	overlays  args
+
+	parsedTimeout     *time.Duration
+	prereqTimeout     time.Duration
+	getPrereqTimeout  time.Duration
+	testTimeout       time.Duration
+	cleanupTimeout    time.Duration
+	killSignal        string
+	parsedSignal      syscall.Signal
+	gracePeriod       time.Duration
+	runAs             string
+	cleanupRunAs      string
+	parsedRunAs       *runner.RunAs
+	parsedCleanupAs   *runner.RunAs
+	environment       runner.Environment
+	phaseEnvironments map[runner.RunPhase]*runner.Environment
+	env               args
+	envAllow          string
+	cleanEnv          bool
+	workdir           string
+	phaseEnv          args
+	phaseWorkdir      args
+	sandbox           bool
+	sandboxFolder     string
+	keepSandbox       bool
+	limits            runner.Limits
+	cgroup            bool
+	cgroupParent      string
+	isolate           string
+	isolateTest       args
+	isolation         *runner.Isolation
+	testIsolation     map[string]*runner.Isolation
+	ssh               string
+	sshKey            string
+	sshKnownHosts     string
+	sshFolder         string
+	target            runner.Target
+	stepConfirmer     runner.StepConfirmer
 }
 
 func processFlags() (*options, error) {
 	opts := options{}
-	flag.StringVar(&opts.atomicsFolder, "path", "", "path to atomics folder")
+	flag.StringVar(&opts.atomicsFolder, "path", "", "path to atomics folder or a .zip or .tar.gz "+
+		"release archive of atomic red team")
 
-	flag.StringVar(&opts.techniqueID, "tech", "", "list of technique id's [ex T1002,T1003]")
+	flag.StringVar(&opts.techniqueID, "tech", "", "list of technique id's, a technique includes its sub-techniques. "+
+		"supports wildcards, ranges and exclusions [ex T1003,T1055.001-T1055.004,T1552.*,!T1485]")
 	flag.StringVar(&opts.number, "num", "", "test case number [1-N]")
 	flag.StringVar(&opts.testName, "name", "", "name of the test to run")
 	flag.StringVar(&opts.guid, "guid", "", "test case guids separated by comma")
-
-	flag.StringVar(&opts.timeout, "timeout", "", "timeout for commands [ex 1s, 2m]")
+	flag.StringVar(&opts.tactic, "tactic", "", "list of ATT&CK tactics, requires -attack [ex discovery,\"Credential Access\"]")
+	flag.StringVar(&opts.attackBundle, "attack", "", "path to a MITRE ATT&CK STIX bundle [ex enterprise-attack.json]")
+	flag.BoolVar(&opts.list, "list", false, "list the selected tests as a table with their ATT&CK tactics")
+
+	flag.StringVar(&opts.timeout, "timeout", "", "overall timeout for the dependencies and the test, "+
+		"cleanup has its own timeout [ex 1s, 2m]")
+	flag.DurationVar(&opts.prereqTimeout, "prereq-timeout", 0, "timeout for the prereq commands of each dependency")
+	flag.DurationVar(&opts.getPrereqTimeout, "getprereq-timeout", 0, "timeout for the getprereq commands of each dependency")
+	flag.DurationVar(&opts.testTimeout, "test-timeout", 0, "timeout for the test commands")
+	flag.DurationVar(&opts.cleanupTimeout, "cleanup-timeout", 0, "timeout for the cleanup commands, "+
+		"cleanup runs even when the test timed out. 0 uses -timeout or 5m when it is not set")
+	flag.StringVar(&opts.killSignal, "kill-signal", "SIGTERM", "signal sent to the commands that time out")
+	flag.DurationVar(&opts.gracePeriod, "grace-period", runner.DefaultGracePeriod, "time given to the commands "+
+		"to exit after -kill-signal before they are killed with SIGKILL")
+
+	flag.IntVar(&opts.parallel, "parallel", 1, "number of tests run at the same time when several tests are selected, "+
+		"results are printed once all of them are done")
 
 	flag.BoolVar(&opts.dryRun, "dry-run", false, "build test and display what will be executed "+
 		"when the test is run")
@@ -240,9 +516,74 @@ func processFlags() (*options, error) {
 	flag.BoolVar(&opts.runDependency, "dependency", false, "check prerequisites and get "+
 		"them if needed")
 
+	flag.StringVar(&opts.runAs, "user", "", "run the dependencies and the test as another account, "+
+		"requires root [ex nobody, 1000:1000]")
+	flag.StringVar(&opts.cleanupRunAs, "cleanup-user", "", "run cleanup as another account, "+
+		"defaults to -user [ex root]")
+
+	flag.Var(&opts.env, "env", "set an environment variable for the commands [ex NAME=value], "+
+		"set multiple times for different variables")
+	flag.StringVar(&opts.envAllow, "env-allow", "", "list of environment variables inherited by the commands, "+
+		"the others are not passed [ex PATH,LANG]")
+	flag.BoolVar(&opts.cleanEnv, "clean-env", false, "run the commands without inheriting any environment variable")
+	flag.StringVar(&opts.workdir, "workdir", "", "working directory of the commands")
+	flag.Var(&opts.phaseEnv, "phase-env", "set an environment variable for the commands of a phase "+
+		"[ex cleanup:NAME=value]")
+	flag.Var(&opts.phaseWorkdir, "phase-workdir", "working directory of the commands of a phase "+
+		"[ex cleanup:/tmp]")
+
+	flag.BoolVar(&opts.sandbox, "sandbox", false, "run every test in a new temporary folder that is also its "+
+		"TMPDIR and #{go_atomic_workdir}, the folder is removed once the test is done")
+	flag.StringVar(&opts.sandboxFolder, "sandbox-dir", "", "folder where the sandboxes are created, "+
+		"requires -sandbox [default is the folder for temporary files]")
+	flag.BoolVar(&opts.keepSandbox, "keep-sandbox", false, "keep the sandbox of every test, requires -sandbox")
+
+	flag.DurationVar(&opts.limits.CPUTime, "limit-cpu", 0, "most CPU time of each process of the commands, "+
+		"rounded up to a second [ex 30s]")
+	flag.Uint64Var(&opts.limits.AddressSpace, "limit-memory", 0, "most bytes of virtual memory of each process "+
+		"of the commands")
+	flag.Uint64Var(&opts.limits.OpenFiles, "limit-files", 0, "most open files of each process of the commands")
+	flag.Uint64Var(&opts.limits.Processes, "limit-procs", 0, "most processes of the account running the commands, "+
+		"not enforced for root")
+	flag.Uint64Var(&opts.limits.FileSize, "limit-fsize", 0, "most bytes of a file written by the commands")
+
+	flag.BoolVar(&opts.cgroup, "cgroup", false, "run every test in a new cgroup v2, processes that escape "+
+		"the commands are killed with them and the ones left after cleanup are listed and killed")
+	flag.StringVar(&opts.cgroupParent, "cgroup-parent", "", "cgroup v2 folder where the cgroups are created, "+
+		"requires -cgroup [default is the cgroup of go-atomic]")
+
+	flag.StringVar(&opts.isolate, "isolate", "", "run the commands in new linux namespaces, "+
+		"optionally with a read-only or overlay root [ex user,mount,pid,network,overlay]")
+	flag.Var(&opts.isolateTest, "isolate-test", "isolation of the tests with a guid or technique id, "+
+		"overrides -isolate, set multiple times for different tests [ex T1485:user,mount,overlay, T1082:none]")
+
+	flag.StringVar(&opts.ssh, "ssh", "", "run the commands on a linux host over ssh, the payloads "+
+		"the tests use are copied to it [ex root@10.0.0.5:22]")
+	flag.StringVar(&opts.sshKey, "ssh-key", "", "private key used to log in with -ssh, "+
+		"the keys of the ssh agent are also used")
+	flag.StringVar(&opts.sshKnownHosts, "ssh-known-hosts", "", "known hosts file that holds the key of "+
+		"the -ssh host [default is ~/.ssh/known_hosts]")
+	flag.StringVar(&opts.sshFolder, "ssh-dir", "", "folder of the -ssh host where the commands run "+
+		"and the payloads are copied [default is ~/go-atomic]")
+
+	flag.BoolVar(&opts.manual, "manual", false, "run manual tests by confirming each step interactively")
+	flag.StringVar(&opts.manualResults, "manual-results", "", "run manual tests using the step results "+
+		"recorded in a yaml file")
+
+	flag.BoolVar(&opts.stream, "stream", false, "print the output of commands to stderr while they run")
+	flag.Int64Var(&opts.maxOutput, "max-output", 0, "most bytes of stdout and of stderr kept for each command, "+
+		"the beginning and the end are kept [default keeps everything]")
+	flag.StringVar(&opts.spillFolder, "spill-dir", "", "folder where the complete output of truncated "+
+		"commands is written, requires -max-output")
+
 	flag.BoolVar(&opts.debug, "debug", false, "show debug logs")
+	flag.StringVar(&opts.cacheFile, "cache", "", "path to the parsed atomics cache [default is in the user cache folder]")
+	flag.BoolVar(&opts.noCache, "no-cache", false, "parse every atomics file instead of using the cache")
+	flag.BoolVar(&opts.strict, "strict", false, "fail if any technique file does not match the atomics schema")
 	flag.Var(&opts.arguments, "arg", "pass argument to test [ex foo=bar], "+
 		"set multiple times for different arguments")
+	flag.Var(&opts.overlays, "overlay", "path to an atomics folder or archive loaded on top of -path, "+
+		"set multiple times in precedence order")
 
 	flag.Parse()
 
@@ -258,6 +599,38 @@ func processFlags() (*options, error) {
 		opts.parsedTimeout = &pt
 	}
 
+	if opts.killSignal != "SIGTERM" {
+		sig, err := runner.ParseSignal(opts.killSignal)
+		if err != nil {
+			return nil, fmt.Errorf("kill-signal is not valid: %s", err)
+		}
+		opts.parsedSignal = sig
+	}
+
+	var err error
+	if opts.environment, opts.phaseEnvironments, err = processEnvironment(&opts); err != nil {
+		return nil, err
+	}
+
+	if opts.isolation, opts.testIsolation, err = processIsolation(&opts); err != nil {
+		return nil, err
+	}
+
+	if opts.runAs != "" {
+		ra, err := runner.ParseRunAs(opts.runAs)
+		if err != nil {
+			return nil, fmt.Errorf("user is not valid: %s", err)
+		}
+		opts.parsedRunAs = ra
+	}
+	if opts.cleanupRunAs != "" {
+		ra, err := runner.ParseRunAs(opts.cleanupRunAs)
+		if err != nil {
+			return nil, fmt.Errorf("cleanup-user is not valid: %s", err)
+		}
+		opts.parsedCleanupAs = ra
+	}
+
 	return &opts, nil
 }
 
@@ -276,6 +649,105 @@ func processArguments(arguments args) (map[string]string, error) {
 	return testArguments, nil
 }
 
+// processIsolation parses the isolation of all the tests and the isolation of single tests.
+func processIsolation(f *options) (*runner.Isolation, map[string]*runner.Isolation, error) {
+	var iso *runner.Isolation
+	if f.isolate != "" {
+		var err error
+		if iso, err = runner.ParseIsolation(f.isolate); err != nil {
+			return nil, nil, fmt.Errorf("isolate is not valid: %s", err)
+		}
+	}
+	var tests map[string]*runner.Isolation
+	for _, value := range f.isolateTest {
+		index := strings.Index(value, ":")
+		if index <= 0 {
+			return nil, nil, fmt.Errorf("%s is not properly formated, use test:isolation format", value)
+		}
+		testIso, err := runner.ParseIsolation(value[index+1:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("isolate-test is not valid: %s", err)
+		}
+		if tests == nil {
+			tests = make(map[string]*runner.Isolation)
+		}
+		tests[value[:index]] = testIso
+	}
+	return iso, tests, nil
+}
+
+// processEnvironment builds the environments of the commands from the flags.
+func processEnvironment(f *options) (runner.Environment, map[runner.RunPhase]*runner.Environment, error) {
+	var env runner.Environment
+	vars, err := processVariables(f.env)
+	if err != nil {
+		return env, nil, err
+	}
+	env.Vars, env.Clean, env.Dir = vars, f.cleanEnv, f.workdir
+	if f.envAllow != "" {
+		env.Allowlist = strings.Split(f.envAllow, ",")
+	}
+
+	var phases map[runner.RunPhase]*runner.Environment
+	phaseEnv := func(value string) (*runner.Environment, string, error) {
+		index := strings.Index(value, ":")
+		if index <= 0 {
+			return nil, "", fmt.Errorf("%s is not properly formated, use phase:value format", value)
+		}
+		phase, err := runner.ParseRunPhase(value[:index])
+		if err != nil {
+			return nil, "", err
+		}
+		if phases == nil {
+			phases = make(map[runner.RunPhase]*runner.Environment)
+		}
+		if phases[phase] == nil {
+			phases[phase] = &runner.Environment{}
+		}
+		return phases[phase], value[index+1:], nil
+	}
+	for _, value := range f.phaseEnv {
+		pe, variable, err := phaseEnv(value)
+		if err != nil {
+			return env, nil, err
+		}
+		vars, err := processVariables(args{variable})
+		if err != nil {
+			return env, nil, err
+		}
+		if pe.Vars == nil {
+			pe.Vars = make(map[string]string)
+		}
+		for name, value := range vars {
+			pe.Vars[name] = value
+		}
+	}
+	for _, value := range f.phaseWorkdir {
+		pe, dir, err := phaseEnv(value)
+		if err != nil {
+			return env, nil, err
+		}
+		pe.Dir = dir
+	}
+	return env, phases, nil
+}
+
+// processVariables parses environment variables in NAME=value format.
+func processVariables(variables args) (map[string]string, error) {
+	var vars map[string]string
+	for _, variable := range variables {
+		index := strings.Index(variable, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("environment variable %s is not properly formated, use NAME=value format", variable)
+		}
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		vars[variable[:index]] = variable[index+1:]
+	}
+	return vars, nil
+}
+
 func getRC(f *options) *runner.TestRunConfig {
 	rc := runner.TestRunConfig{
 		EnableCheckPreReq:  f.runCheckPreReq,
@@ -287,31 +759,53 @@ func getRC(f *options) *runner.TestRunConfig {
 	if f.runAll {
 		rc.EnableAll = true
 	}
+	rc.StepConfirmer = f.stepConfirmer
+	if f.parsedTimeout != nil {
+		rc.Timeout = *f.parsedTimeout
+	}
+	rc.PreReqTimeout, rc.GetPreReqTimeout = f.prereqTimeout, f.getPrereqTimeout
+	rc.TestTimeout, rc.CleanupTimeout = f.testTimeout, f.cleanupTimeout
+	rc.MaxStdoutBytes, rc.MaxStderrBytes = f.maxOutput, f.maxOutput
+	rc.OutputSpillFolder = f.spillFolder
+	rc.TerminationSignal, rc.TerminationGracePeriod = f.parsedSignal, f.gracePeriod
+	rc.RunAs, rc.CleanupRunAs = f.parsedRunAs, f.parsedCleanupAs
+	rc.Environment, rc.PhaseEnvironments = f.environment, f.phaseEnvironments
+	rc.Sandbox, rc.SandboxFolder, rc.KeepSandbox = f.sandbox, f.sandboxFolder, f.keepSandbox
+	rc.Limits = f.limits
+	rc.Cgroup, rc.CgroupParent = f.cgroup, f.cgroupParent
+	rc.Isolation, rc.TestIsolation = f.isolation, f.testIsolation
+	rc.Target = f.target
+	if f.stream {
+		rc.OutputHandler = streamOutput
+	}
 	return &rc
 }
 
+// streamOutput prints command output to stderr prefixed with the test and the phase it
+// belongs to, so that the results printed on stdout stay valid json.
+func streamOutput(event runner.OutputEvent) {
+	data := event.Data
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	fmt.Fprintf(os.Stderr, "[%s %s %s] %s", event.TechniqueID, event.Phase, event.Stream, data)
+}
+
 func runTest(ar *runner.Runner, at *art.Test, testArguments map[string]string, options *options) int {
+	// unknown arguments have been rejected already, the others may belong to other selected tests.
+	testArguments = declaredArguments(at, testArguments)
 	if options.dryRun {
 		br, err := ar.BuildTest(at, testArguments)
 		displayBuiltTestInfo(br, err)
 		return 0
 	}
-	if options.runCleanup && at.Executor.CleanupCommand == "" {
-		fmt.Fprintf(os.Stderr, "no cleanup command for test %s:%s", at.TechniqueID, at.Name)
-		return 1
-	}
-	if (options.runDependency || options.runCheckPreReq) && len(at.Dependencies) == 0 {
-		fmt.Fprintf(os.Stderr, "no dependencies for test %s:%s", at.TechniqueID, at.Name)
+	if err := checkRunnable(at, options); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err)
 		return 1
 	}
 
 	rc := getRC(options)
-	var cancel context.CancelFunc
 	ctx := context.Background()
-	if options.parsedTimeout != nil {
-		ctx, cancel = context.WithTimeout(ctx, *options.parsedTimeout)
-		defer cancel()
-	}
 
 	if options.isRun {
 		// error is ignored here since the error message is also part of the result struct
@@ -326,6 +820,36 @@ func runTest(ar *runner.Runner, at *art.Test, testArguments map[string]string, o
 	return 0
 }
 
+// checkRunnable reports tests that have nothing to run for the selected options.
+func checkRunnable(at *art.Test, options *options) error {
+	if options.runCleanup && at.Executor.CleanupCommand == "" {
+		return fmt.Errorf("no cleanup command for test %s:%s", at.TechniqueID, at.Name)
+	}
+	if (options.runDependency || options.runCheckPreReq) && len(at.Dependencies) == 0 {
+		return fmt.Errorf("no dependencies for test %s:%s", at.TechniqueID, at.Name)
+	}
+	return nil
+}
+
+// runTests runs the tests with -parallel workers. The results are printed in the order of
+// tests once all of them are done.
+func runTests(ar *runner.Runner, tests []*art.Test, testArguments map[string]string, options *options) {
+	var runs []runner.TestRun
+	for _, test := range tests {
+		if err := checkRunnable(test, options); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			continue
+		}
+		runs = append(runs, runner.TestRun{Test: test, Arguments: declaredArguments(test, testArguments)})
+	}
+	for _, result := range ar.RunTests(context.Background(), runs, getRC(options), options.parallel) {
+		if options.debug {
+			logger.Printf("%s\n", result.Err)
+		}
+		displayTestResult(result.Info, result.Err)
+	}
+}
+
 func runTechnique(ar *runner.Runner, tech *art.Technique, testArguments map[string]string, options *options) {
 	for _, test := range tech.AtomicTests {
 		// ignore return codes when multiple tests are run
@@ -333,6 +857,42 @@ func runTechnique(ar *runner.Runner, tech *art.Technique, testArguments map[stri
 	}
 }
 
+// listTests prints the selected tests as a table. The test number can be used with -num.
+func listTests(ar *runner.Runner, techniques []*art.Technique) {
+	sort.Slice(techniques, func(i, j int) bool {
+		return techniques[i].ID < techniques[j].ID
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TECHNIQUE\tNUM\tTACTICS\tGUID\tNAME")
+	for _, tech := range techniques {
+		tactics := "-"
+		if tech.Attack != nil && len(tech.Attack.Tactics) > 0 {
+			tactics = strings.Join(tech.Attack.Tactics, ",")
+		}
+		// filtered techniques only contain some of the tests, so the number is looked up
+		// in the complete technique.
+		var all []*art.Test
+		if full, err := ar.GetTechnique(tech.ID); err == nil {
+			all = full.AtomicTests
+		}
+		for _, test := range tech.AtomicTests {
+			num := "-"
+			for index, candidate := range all {
+				if candidate == test {
+					num = strconv.Itoa(index + 1)
+					break
+				}
+			}
+			guid := test.AutoGeneratedGUID
+			if guid == "" {
+				guid = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", tech.ID, num, tactics, guid, test.Name)
+		}
+	}
+	w.Flush()
+}
+
 func displayTestInfo(atomicTest *art.Test) {
 	dumpJSON(atomicTest)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/ejohn/go-atomic/runner"
+)
+
+// dialTarget connects to the host of -ssh, given as [user@]host[:port]. The keys of
+// -ssh-key and of the ssh agent are used to authenticate, and the host key has to be in
+// the known hosts file.
+func dialTarget(f *options) (*runner.SSHTarget, error) {
+	user, address := "", f.ssh
+	if index := strings.LastIndex(address, "@"); index >= 0 {
+		user, address = address[:index], address[index+1:]
+	}
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	var auth []ssh.AuthMethod
+	if f.sshKey != "" {
+		data, err := ioutil.ReadFile(f.sshKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ssh key: %s", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("ssh key is not valid: %s", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			defer conn.Close()
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	knownHosts := f.sshKnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known hosts: %s", err)
+	}
+
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %s", f.ssh, err)
+	}
+	target, err := runner.NewSSHTarget(client, f.sshFolder)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("%s: %s", f.ssh, err)
+	}
+	return target, nil
+}
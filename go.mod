@@ -1,12 +1,18 @@
 module github.com/ejohn/go-atomic
 
-go 1.14
+go 1.20
 
 require (
-	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/hashicorp/go-multierror v1.1.0
-	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.5.1
-	golang.org/x/sys v0.0.0-20200428200454-593003d681fa
-	gopkg.in/yaml.v2 v2.2.8
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
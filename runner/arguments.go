@@ -0,0 +1,213 @@
+package runner
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+// Argument types used by atomic red team in input_arguments. Types are compared
+// without case since the atomics are not consistent about it.
+const (
+	pathArgument    = "path"
+	urlArgument     = "url"
+	stringArgument  = "string"
+	integerArgument = "integer"
+	floatArgument   = "float"
+)
+
+// ArgumentProblemKind classifies the problems found in user supplied arguments.
+type ArgumentProblemKind string
+
+// Various ArgumentProblemKind's reported in an ArgumentError.
+const (
+	UnknownArgument ArgumentProblemKind = "unknown argument"
+	InvalidArgument ArgumentProblemKind = "invalid value"
+)
+
+// ArgumentProblem describes a single user supplied argument that was rejected.
+// Suggestion is set to the closest declared argument name for unknown arguments.
+type ArgumentProblem struct {
+	Kind       ArgumentProblemKind
+	Name       string
+	Value      string
+	Message    string
+	Suggestion string
+}
+
+func (ap ArgumentProblem) String() string {
+	msg := fmt.Sprintf("%s %q: %s", ap.Kind, ap.Name, ap.Message)
+	if ap.Suggestion != "" {
+		msg = fmt.Sprintf("%s, did you mean %q?", msg, ap.Suggestion)
+	}
+	return msg
+}
+
+// ArgumentError is returned when user supplied arguments do not match the input
+// arguments declared by an atomic test. It lists every problem found.
+type ArgumentError struct {
+	TestName string
+	Problems []ArgumentProblem
+}
+
+func (ae *ArgumentError) Error() string {
+	msgs := make([]string, 0, len(ae.Problems))
+	for _, problem := range ae.Problems {
+		msgs = append(msgs, problem.String())
+	}
+	return fmt.Sprintf("invalid arguments for test %q: %s", ae.TestName, strings.Join(msgs, "; "))
+}
+
+// buildArguments combines the default arguments of a test with the user supplied ones.
+// User supplied values are validated against the declared type of the argument and
+// normalized. All the problems found are returned together as an *ArgumentError.
+func buildArguments(defaultArgs map[string]art.Argument, args map[string]string, atomicsFolder string) (map[string]string, error) {
+	combined := make(map[string]string)
+	for k, v := range defaultArgs {
+		combined[k] = v.Default
+	}
+
+	// sort user supplied names so that problems are reported in a stable order
+	names := make([]string, 0, len(args))
+	for k := range args {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var problems []ArgumentProblem
+	for _, name := range names {
+		value := args[name]
+		declared, found := defaultArgs[name]
+		if !found {
+			problems = append(problems, ArgumentProblem{
+				Kind:       UnknownArgument,
+				Name:       name,
+				Value:      value,
+				Message:    "not an input argument of the test",
+				Suggestion: closestArgument(name, defaultArgs),
+			})
+			continue
+		}
+		normalized, err := coerceArgument(declared.Type, value)
+		if err != nil {
+			problems = append(problems, ArgumentProblem{
+				Kind:    InvalidArgument,
+				Name:    name,
+				Value:   value,
+				Message: err.Error(),
+			})
+			continue
+		}
+		combined[name] = normalized
+	}
+	for k := range combined {
+		arg := strings.ReplaceAll(combined[k], "$PathToAtomicsFolder", atomicsFolder)
+		arg = strings.ReplaceAll(arg, "PathToAtomicsFolder", atomicsFolder)
+		combined[k] = arg
+	}
+	if len(problems) > 0 {
+		return combined, &ArgumentError{Problems: problems}
+	}
+	return combined, nil
+}
+
+// coerceArgument checks that value is valid for the argument type and returns it in
+// its normalized form. Unknown types are treated as strings.
+func coerceArgument(argType, value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(argType)) {
+	case pathArgument:
+		if value == "" {
+			return "", fmt.Errorf("path cannot be empty")
+		}
+		return cleanPath(value), nil
+	case urlArgument:
+		u, err := url.Parse(strings.TrimSpace(value))
+		if err != nil || u.Scheme == "" || (u.Host == "" && u.Opaque == "") {
+			return "", fmt.Errorf("%q is not a valid url", value)
+		}
+		return u.String(), nil
+	case integerArgument:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid integer", value)
+		}
+		return strconv.FormatInt(i, 10), nil
+	case floatArgument:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid float", value)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	default:
+		return value, nil
+	}
+}
+
+// cleanPath cleans a path argument while keeping the parts that change its meaning in a
+// command: a leading ./ makes shells run a local file instead of looking it up in PATH,
+// and a trailing separator marks a folder.
+func cleanPath(value string) string {
+	cleaned := filepath.Clean(value)
+	separator := string(filepath.Separator)
+	if len(value) > 1 && value[0] == '.' && os.IsPathSeparator(value[1]) &&
+		cleaned != "." && cleaned != ".." && !strings.HasPrefix(cleaned, ".."+separator) {
+		cleaned = "." + separator + cleaned
+	}
+	if os.IsPathSeparator(value[len(value)-1]) && !strings.HasSuffix(cleaned, separator) {
+		cleaned += separator
+	}
+	return cleaned
+}
+
+// closestArgument returns the declared argument name closest to name, or an empty
+// string when none of them are close enough to be a likely typo.
+func closestArgument(name string, declared map[string]art.Argument) string {
+	best := ""
+	bestDistance := len(name)/3 + 2
+	for candidate := range declared {
+		distance := levenshtein(strings.ToLower(name), strings.ToLower(candidate))
+		if distance < bestDistance || (distance == bestDistance && best != "" && candidate < best) {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
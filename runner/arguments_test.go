@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+func TestBuildArguments_Coercion(t *testing.T) {
+	declared := map[string]art.Argument{
+		"output_file": {Type: "Path", Default: "PathToAtomicsFolder/out.txt"},
+		"count":       {Type: "integer", Default: "1"},
+		"ratio":       {Type: "Float", Default: "0.5"},
+		"remote":      {Type: "Url", Default: "https://example.com"},
+		"name":        {Type: "String", Default: "default"},
+	}
+	args, err := buildArguments(declared, map[string]string{
+		"output_file": "/tmp//loot/../out.txt",
+		"count":       " 42 ",
+		"ratio":       "1.50",
+		"name":        " spaced ",
+	}, "/atomics")
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/out.txt", args["output_file"])
+	assert.Equal(t, "42", args["count"])
+	assert.Equal(t, "1.5", args["ratio"])
+	assert.Equal(t, "https://example.com", args["remote"])
+	assert.Equal(t, " spaced ", args["name"])
+
+	args, err = buildArguments(declared, nil, "/atomics")
+	require.NoError(t, err)
+	assert.Equal(t, "/atomics/out.txt", args["output_file"])
+}
+
+func TestCleanPath(t *testing.T) {
+	assert.Equal(t, filepath.FromSlash("./payload"), cleanPath("./payload"))
+	assert.Equal(t, filepath.FromSlash("./bin/payload"), cleanPath(".//bin/./payload"))
+	assert.Equal(t, filepath.FromSlash("payload"), cleanPath("payload"))
+	assert.Equal(t, filepath.FromSlash("../payload"), cleanPath("./../payload"))
+	assert.Equal(t, filepath.FromSlash("/tmp/loot/"), cleanPath("/tmp//loot/"))
+	assert.Equal(t, filepath.FromSlash("./"), cleanPath("./"))
+	assert.Equal(t, filepath.FromSlash("/"), cleanPath("/"))
+}
+
+func TestBuildArguments_Problems(t *testing.T) {
+	declared := map[string]art.Argument{
+		"output_file": {Type: "Path"},
+		"count":       {Type: "Integer"},
+		"remote":      {Type: "url"},
+	}
+	_, err := buildArguments(declared, map[string]string{
+		"outptu_file": "/tmp/out.txt",
+		"count":       "many",
+		"remote":      "example.com",
+		"unrelated":   "x",
+	}, "")
+	require.Error(t, err)
+	argErr, ok := err.(*ArgumentError)
+	require.True(t, ok)
+	require.Equal(t, 4, len(argErr.Problems))
+
+	assert.Equal(t, InvalidArgument, argErr.Problems[0].Kind)
+	assert.Equal(t, "count", argErr.Problems[0].Name)
+	assert.Equal(t, UnknownArgument, argErr.Problems[1].Kind)
+	assert.Equal(t, "output_file", argErr.Problems[1].Suggestion)
+	assert.Equal(t, InvalidArgument, argErr.Problems[2].Kind)
+	assert.Equal(t, "remote", argErr.Problems[2].Name)
+	assert.Equal(t, UnknownArgument, argErr.Problems[3].Kind)
+	assert.Equal(t, "", argErr.Problems[3].Suggestion)
+	assert.Contains(t, err.Error(), `unknown argument "outptu_file": not an input argument of the test, did you mean "output_file"?`)
+}
+
+func TestBuildTest_ArgumentError(t *testing.T) {
+	ar := Runner{}
+	at, args := getMockTest()
+	args["comand"] = "typo"
+	_, err := ar.BuildTest(at, args)
+	require.Error(t, err)
+	argErr, ok := err.(*ArgumentError)
+	require.True(t, ok)
+	assert.Equal(t, "Test", argErr.TestName)
+	assert.Equal(t, "command", argErr.Problems[0].Suggestion)
+}
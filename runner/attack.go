@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+// stixBundle is the subset of a MITRE ATT&CK STIX 2 bundle used to enrich techniques.
+// https://github.com/mitre/cti/blob/master/USAGE.md
+type stixBundle struct {
+	Objects []stixObject `json:"objects"`
+}
+
+type stixObject struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Name               string `json:"name"`
+	Revoked            bool   `json:"revoked"`
+	Deprecated         bool   `json:"x_mitre_deprecated"`
+	ExternalReferences []struct {
+		SourceName string `json:"source_name"`
+		ExternalID string `json:"external_id"`
+	} `json:"external_references"`
+	KillChainPhases []struct {
+		KillChainName string `json:"kill_chain_name"`
+		PhaseName     string `json:"phase_name"`
+	} `json:"kill_chain_phases"`
+	DataSources      []string `json:"x_mitre_data_sources"`
+	DataSourceRef    string   `json:"x_mitre_data_source_ref"`
+	RelationshipType string   `json:"relationship_type"`
+	SourceRef        string   `json:"source_ref"`
+	TargetRef        string   `json:"target_ref"`
+}
+
+const attackSource = "mitre-attack"
+
+// attackID returns the ATT&CK id of an object, for example T1003.001.
+func (so *stixObject) attackID() string {
+	for _, ref := range so.ExternalReferences {
+		if ref.SourceName == attackSource {
+			return ref.ExternalID
+		}
+	}
+	return ""
+}
+
+// loadAttackBundle reads a STIX bundle and returns the ATT&CK details of every technique
+// in it keyed by technique id.
+func loadAttackBundle(file string) (map[string]*art.AttackInfo, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var bundle stixBundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return nil, fmt.Errorf("unable to parse STIX bundle %s: %s", file, err)
+	}
+
+	byRef := make(map[string]*stixObject)
+	for index := range bundle.Objects {
+		obj := &bundle.Objects[index]
+		byRef[obj.ID] = obj
+	}
+
+	infos := make(map[string]*art.AttackInfo)
+	techIDs := make(map[string]string) // stix id -> technique id
+	for index := range bundle.Objects {
+		obj := &bundle.Objects[index]
+		if obj.Type != "attack-pattern" {
+			continue
+		}
+		id := obj.attackID()
+		if id == "" {
+			continue
+		}
+		// revoked patterns can share an id with the pattern that replaced them.
+		if existing, found := infos[id]; found && !existing.Revoked {
+			continue
+		}
+		info := &art.AttackInfo{
+			Name:        obj.Name,
+			DataSources: append([]string(nil), obj.DataSources...),
+			Deprecated:  obj.Deprecated,
+			Revoked:     obj.Revoked,
+		}
+		for _, phase := range obj.KillChainPhases {
+			if phase.KillChainName == attackSource {
+				info.Tactics = append(info.Tactics, phase.PhaseName)
+			}
+		}
+		if index := strings.Index(id, "."); index > 0 {
+			info.ParentTechnique = id[:index]
+		}
+		infos[id] = info
+		techIDs[obj.ID] = id
+	}
+
+	for index := range bundle.Objects {
+		rel := &bundle.Objects[index]
+		if rel.Type != "relationship" || rel.Revoked || rel.Deprecated {
+			continue
+		}
+		switch rel.RelationshipType {
+		case "subtechnique-of":
+			sub, parent := techIDs[rel.SourceRef], techIDs[rel.TargetRef]
+			if sub == "" || parent == "" {
+				continue
+			}
+			infos[sub].ParentTechnique = parent
+			infos[parent].SubTechniques = appendUnique(infos[parent].SubTechniques, sub)
+		case "detects":
+			// newer versions of ATT&CK describe data sources through data components.
+			target := techIDs[rel.TargetRef]
+			component, found := byRef[rel.SourceRef]
+			if target == "" || !found || component.Type != "x-mitre-data-component" {
+				continue
+			}
+			name := component.Name
+			if source, found := byRef[component.DataSourceRef]; found {
+				name = source.Name + ": " + component.Name
+			}
+			infos[target].DataSources = appendUnique(infos[target].DataSources, name)
+		}
+	}
+	for _, info := range infos {
+		sort.Strings(info.SubTechniques)
+		sort.Strings(info.DataSources)
+	}
+	return infos, nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, item := range list {
+		if item == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// enrichTechniques sets the ATT&CK details on every technique found in the bundle.
+func (ar *Runner) enrichTechniques(techniques map[string]*art.Technique) error {
+	infos, err := loadAttackBundle(ar.AttackBundle)
+	if err != nil {
+		return err
+	}
+	for id, technique := range techniques {
+		info, found := infos[id]
+		if !found {
+			ar.debugf("technique %s not found in ATT&CK bundle", id)
+			continue
+		}
+		technique.Attack = info
+	}
+	return nil
+}
+
+// normalizeTactic turns a tactic name like "Credential Access" into the kill chain phase
+// name used by ATT&CK.
+func normalizeTactic(tactic string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tactic)), " ", "-")
+}
+
+// hasTactic reports whether a technique belongs to any of the wanted tactics.
+func hasTactic(technique *art.Technique, tactics []string) bool {
+	if technique.Attack == nil {
+		return false
+	}
+	for _, want := range tactics {
+		for _, tactic := range technique.Attack.Tactics {
+			if normalizeTactic(want) == tactic {
+				return true
+			}
+		}
+	}
+	return false
+}
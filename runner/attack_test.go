@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testAttackBundle = filepath.Join(testFolder, "attack", "enterprise-attack.json")
+
+func TestLoadAttackBundle(t *testing.T) {
+	infos, err := loadAttackBundle(testAttackBundle)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(infos))
+
+	parent := infos["T9999"]
+	assert.Equal(t, "Test Technique", parent.Name)
+	assert.Equal(t, []string{"discovery", "credential-access"}, parent.Tactics)
+	assert.Equal(t, []string{"T9999.001"}, parent.SubTechniques)
+	assert.Equal(t, []string{"Command: Command Execution"}, parent.DataSources)
+	assert.Equal(t, "T9999", infos["T9999.001"].ParentTechnique)
+	assert.True(t, infos["T9998"].Deprecated)
+	assert.Equal(t, []string{"Process: Process Creation"}, infos["T9998"].DataSources)
+	assert.True(t, infos["T9997"].Revoked)
+}
+
+func TestFilter_Tactics(t *testing.T) {
+	ar := &Runner{AtomicsFolder: testFolder, AttackBundle: testAttackBundle}
+	_, err := ar.LoadTechniques()
+	require.NoError(t, err)
+	tech, err := ar.GetTechnique("T9999")
+	require.NoError(t, err)
+	require.NotNil(t, tech.Attack)
+	// techniques missing from the bundle are not enriched
+	tech, err = ar.GetTechnique("T9996")
+	require.NoError(t, err)
+	assert.Nil(t, tech.Attack)
+
+	filtered := ar.Filter(&FilterConfig{Tactics: []string{"Credential Access"}, IncludeManual: true})
+	require.Equal(t, 1, len(filtered))
+	assert.Equal(t, "T9999", filtered[0].ID)
+	assert.NotNil(t, filtered[0].Attack)
+
+	filtered = ar.Filter(&FilterConfig{Tactics: []string{"execution", "discovery"}, IncludeManual: true})
+	assert.Equal(t, 2, len(filtered))
+
+	filtered = ar.Filter(&FilterConfig{Tactics: []string{"impact"}, IncludeManual: true})
+	assert.Equal(t, 0, len(filtered))
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+// TestRun is a test to be run by RunTests along with its input arguments.
+type TestRun struct {
+	Test      *art.Test
+	Arguments map[string]string
+}
+
+// TestResult is the outcome of a test run by RunTests. Err is what RunTest returned.
+type TestResult struct {
+	Test *art.Test
+	Info *TestRunInfo
+	Err  error
+}
+
+// RunTests runs tests with rc using at most concurrency workers, a concurrency below one
+// runs the tests one at a time. Results are returned in the order of tests, no matter in
+// which order the tests finished.
+//
+// Every test is run with RunTest, so its cleanup only starts once its commands are done.
+// When the same test is in the list more than once, its runs are not started before the
+// previous run finished its cleanup. Tests that were not started when ctx is done are
+// reported with the error of ctx.
+//
+// rc is shared by all the tests, its StepConfirmer and OutputHandler have to be safe for
+// concurrent use when concurrency is above one.
+func (ar *Runner) RunTests(ctx context.Context, tests []TestRun, rc *TestRunConfig, concurrency int) []TestResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(tests) {
+		concurrency = len(tests)
+	}
+	results := make([]TestResult, len(tests))
+
+	// runs of the same test hold its lock from the first dependency to the end of cleanup.
+	locks := make(map[*art.Test]*sync.Mutex)
+	for _, test := range tests {
+		if locks[test.Test] == nil {
+			locks[test.Test] = &sync.Mutex{}
+		}
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				test := tests[index]
+				results[index].Test = test.Test
+				if err := ctx.Err(); err != nil {
+					results[index].Err = err
+					continue
+				}
+				lock := locks[test.Test]
+				lock.Lock()
+				results[index].Info, results[index].Err = ar.RunTest(ctx, test.Test, test.Arguments, rc)
+				lock.Unlock()
+			}
+		}()
+	}
+	for index := range tests {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+// catalogCacheVersion has to be changed whenever the parsing or validation rules change
+// so that results cached by older versions are not used.
+const catalogCacheVersion = 2
+
+// catalogCache holds the parsed technique files of previous runs keyed by their absolute path.
+type catalogCache struct {
+	Version int
+	Entries map[string]cacheEntry
+
+	dirty bool
+}
+
+// cacheEntry is a parsed technique file. The entry is only valid as long as the size
+// and modification time of the file match.
+type cacheEntry struct {
+	Size      int64
+	ModTime   time.Time
+	Technique art.Technique
+	Issues    []LoadIssue
+}
+
+// cachePath returns the location of the catalog cache or an empty string when caching
+// is not enabled.
+func (ar *Runner) cachePath() string {
+	if !ar.EnableCache {
+		return ""
+	}
+	if ar.CacheFile != "" {
+		return ar.CacheFile
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		ar.debugf("catalog cache disabled: %s", err)
+		return ""
+	}
+	return filepath.Join(dir, "go-atomic", "catalog.json")
+}
+
+// loadCatalogCache reads the cache from file. A missing, unreadable or outdated cache
+// results in an empty one since it can always be rebuilt.
+func loadCatalogCache(file string) *catalogCache {
+	empty := &catalogCache{Version: catalogCacheVersion, Entries: make(map[string]cacheEntry)}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return empty
+	}
+	var cache catalogCache
+	if err := json.Unmarshal(content, &cache); err != nil || cache.Version != catalogCacheVersion || cache.Entries == nil {
+		return empty
+	}
+	return &cache
+}
+
+// lookup returns the cached results for a file if it did not change since it was cached.
+func (cc *catalogCache) lookup(path string, file yamlFile) (art.Technique, []LoadIssue, bool) {
+	entry, found := cc.Entries[path]
+	if !found || entry.Size != file.size || !entry.ModTime.Equal(file.modTime) {
+		return art.Technique{}, nil, false
+	}
+	return entry.Technique, entry.Issues, true
+}
+
+func (cc *catalogCache) store(path string, file yamlFile, technique art.Technique, issues []LoadIssue) {
+	cc.Entries[path] = cacheEntry{
+		Size:      file.size,
+		ModTime:   file.modTime,
+		Technique: technique,
+		Issues:    issues,
+	}
+	cc.dirty = true
+}
+
+// prune removes the entries for technique files of folder that were not seen while loading
+// it. Entries of other atomics folders nested inside folder are left alone.
+func (cc *catalogCache) prune(folder string, seen map[string]bool) {
+	prefix := folder + string(filepath.Separator)
+	for path := range cc.Entries {
+		if !strings.HasPrefix(path, prefix) || seen[path] {
+			continue
+		}
+		if isTechniqueFile(filepath.ToSlash(strings.TrimPrefix(path, prefix))) {
+			delete(cc.Entries, path)
+			cc.dirty = true
+		}
+	}
+}
+
+// save writes the cache to file if it changed. The cache is written to a temporary file
+// first and renamed so that concurrent runs never see a partially written cache.
+func (cc *catalogCache) save(file string) error {
+	if !cc.dirty {
+		return nil
+	}
+	content, err := json.Marshal(cc)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	cc.dirty = false
+	return nil
+}
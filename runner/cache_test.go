@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTechniques_Cache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	atomics := filepath.Join(dir, "atomics")
+	yamlFile := filepath.Join(atomics, "T9999", "T9999.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(yamlFile), 0755))
+	content, err := ioutil.ReadFile(filepath.Join(testFolder, "T9999", "T9999.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(yamlFile, content, 0644))
+	cacheFile := filepath.Join(dir, "cache", "catalog.json")
+
+	ar := &Runner{AtomicsFolder: atomics, EnableCache: true, CacheFile: cacheFile}
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	cache := loadCatalogCache(cacheFile)
+	abs, err := filepath.Abs(yamlFile)
+	require.NoError(t, err)
+	require.Contains(t, cache.Entries, abs)
+
+	// change the cached entry to find out whether the file gets parsed again.
+	entry := cache.Entries[abs]
+	entry.Technique.DisplayName = "Cached"
+	cache.Entries[abs] = entry
+	cache.dirty = true
+	require.NoError(t, cache.save(cacheFile))
+
+	report, err := ar.LoadTechniques()
+	require.NoError(t, err)
+	tech, err := ar.GetTechnique("T9999")
+	require.NoError(t, err)
+	assert.Equal(t, "Cached", tech.DisplayName)
+	assert.Equal(t, abs, mustAbs(t, tech.Path))
+	assert.Equal(t, atomics, tech.AtomicTests[0].AtomicsFolder)
+	// issues are cached along with the technique
+	require.Equal(t, 1, len(report.Files))
+
+	// modified files are parsed again
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(yamlFile, future, future))
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	tech, err = ar.GetTechnique("T9999")
+	require.NoError(t, err)
+	assert.Equal(t, "TestData", tech.DisplayName)
+
+	// entries of deleted files are removed
+	require.NoError(t, os.Remove(yamlFile))
+	_, err = ar.LoadTechniques()
+	require.Error(t, err)
+	assert.NotContains(t, loadCatalogCache(cacheFile).Entries, abs)
+}
+
+func TestLoadTechniques_CacheNestedOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cacheFile := filepath.Join(dir, "catalog.json")
+	overlay := filepath.Join(testFolder, "overlay")
+	ar := &Runner{AtomicsFolder: testFolder, OverlayFolders: []string{overlay}, EnableCache: true, CacheFile: cacheFile}
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+
+	// loading the base folder must not evict the entries of the overlay inside it.
+	abs := mustAbs(t, filepath.Join(overlay, "T9995", "T9995.yaml"))
+	cache := loadCatalogCache(cacheFile)
+	require.Contains(t, cache.Entries, abs)
+	entry := cache.Entries[abs]
+	entry.Technique.DisplayName = "Cached"
+	cache.Entries[abs] = entry
+	cache.dirty = true
+	require.NoError(t, cache.save(cacheFile))
+
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	tech, err := ar.GetTechnique("T9995")
+	require.NoError(t, err)
+	assert.Equal(t, "Cached", tech.DisplayName)
+}
+
+func TestLoadTechniques_CacheDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cacheFile := filepath.Join(dir, "catalog.json")
+	ar := &Runner{AtomicsFolder: testFolder, CacheFile: cacheFile}
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	_, err = os.Stat(cacheFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func mustAbs(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	require.NoError(t, err)
+	return abs
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// capture collects the output of a stream. When limit is set, only the first and the last
+// limit/2 bytes are kept in memory. When spillDir is set, the complete stream is also
+// written to a file which is only kept when the output was truncated.
+type capture struct {
+	limit int64
+	head  []byte
+	// tail holds the most recent output, only its last tailLimit bytes are kept.
+	tail  []byte
+	total int64
+
+	spill    *os.File
+	spillErr error
+}
+
+func newCapture(limit int64, spillDir, name string) (*capture, error) {
+	c := &capture{limit: limit}
+	if limit > 0 && spillDir != "" {
+		var err error
+		if c.spill, err = ioutil.TempFile(spillDir, name+"-*.log"); err != nil {
+			return nil, fmt.Errorf("unable to create output file: %s", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *capture) headLimit() int64 {
+	return c.limit - c.limit/2
+}
+
+func (c *capture) tailLimit() int64 {
+	return c.limit / 2
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	n := len(p)
+	c.total += int64(n)
+	if c.spill != nil && c.spillErr == nil {
+		_, c.spillErr = c.spill.Write(p)
+	}
+	if c.limit <= 0 {
+		c.head = append(c.head, p...)
+		return n, nil
+	}
+	if room := c.headLimit() - int64(len(c.head)); room > 0 {
+		if int64(len(p)) <= room {
+			c.head = append(c.head, p...)
+			return n, nil
+		}
+		c.head = append(c.head, p[:room]...)
+		p = p[room:]
+	}
+	tailLimit := c.tailLimit()
+	if int64(len(p)) >= tailLimit {
+		c.tail = append(c.tail[:0], p[int64(len(p))-tailLimit:]...)
+		return n, nil
+	}
+	c.tail = append(c.tail, p...)
+	// the tail is compacted once it holds twice what is kept
+	if int64(len(c.tail)) > 2*tailLimit {
+		c.tail = append(c.tail[:0], c.tail[int64(len(c.tail))-tailLimit:]...)
+	}
+	return n, nil
+}
+
+func (c *capture) keptTail() []byte {
+	if tailLimit := c.tailLimit(); int64(len(c.tail)) > tailLimit {
+		return c.tail[int64(len(c.tail))-tailLimit:]
+	}
+	return c.tail
+}
+
+// String returns the output that was kept.
+func (c *capture) String() string {
+	return string(c.head) + string(c.keptTail())
+}
+
+// dropped returns the number of bytes written that were not kept.
+func (c *capture) dropped() int64 {
+	return c.total - int64(len(c.head)) - int64(len(c.keptTail()))
+}
+
+// close finishes the spill file and returns its path when it has to be kept. Files that
+// could not be written completely are removed.
+func (c *capture) close() string {
+	if c.spill == nil {
+		return ""
+	}
+	name := c.spill.Name()
+	if err := c.spill.Close(); err != nil && c.spillErr == nil {
+		c.spillErr = err
+	}
+	if c.spillErr != nil || c.dropped() == 0 {
+		_ = os.Remove(name)
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	c, err := newCapture(0, "", "test")
+	require.NoError(t, err)
+	_, _ = c.Write([]byte("hello "))
+	_, _ = c.Write([]byte("world"))
+	assert.Equal(t, "hello world", c.String())
+	assert.Equal(t, int64(0), c.dropped())
+	assert.Equal(t, "", c.close())
+
+	c, err = newCapture(10, "", "test")
+	require.NoError(t, err)
+	for _, chunk := range []string{"abc", "defgh", "ijklm", "nopq", "r", "stu", "vwxyz"} {
+		n, err := c.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "abcdevwxyz", c.String())
+	assert.Equal(t, int64(16), c.dropped())
+
+	// a single write larger than the limit
+	c, err = newCapture(4, "", "test")
+	require.NoError(t, err)
+	_, _ = c.Write([]byte(strings.Repeat("x", 100) + "end"))
+	assert.Equal(t, "xxnd", c.String())
+	assert.Equal(t, int64(99), c.dropped())
+}
+
+func TestCapture_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := newCapture(4, dir, "stdout")
+	require.NoError(t, err)
+	_, _ = c.Write([]byte("0123456789"))
+	file := c.close()
+	require.NotEmpty(t, file)
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(content))
+
+	// the file is removed when nothing was dropped
+	c, err = newCapture(4, dir, "stdout")
+	require.NoError(t, err)
+	_, _ = c.Write([]byte("0123"))
+	assert.Equal(t, "", c.close())
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+// scanOutput is how output used to be read, one byte at a time. It is kept to compare the
+// throughput with readOutput.
+func scanOutput(w *capture, r *bytes.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanBytes)
+	for scanner.Scan() {
+		_, _ = w.Write(scanner.Bytes())
+	}
+}
+
+func BenchmarkReadOutput(b *testing.B) {
+	for _, size := range []int{1 << 20, 8 << 20} {
+		data := bytes.Repeat([]byte("output line\n"), size/12)
+		b.Run(fmt.Sprintf("scanner/%dMB", size>>20), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				c, _ := newCapture(0, "", "bench")
+				scanOutput(c, bytes.NewReader(data))
+			}
+		})
+		b.Run(fmt.Sprintf("block/%dMB", size>>20), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				c, _ := newCapture(0, "", "bench")
+				readOutput(c, bytes.NewReader(data), &readActivity{})
+			}
+		})
+	}
+}
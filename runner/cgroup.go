@@ -0,0 +1,25 @@
+package runner
+
+import "time"
+
+// ProcessInfo is a process that was still running in the cgroup of a test once the test
+// was done.
+type ProcessInfo struct {
+	PID     int
+	Command string
+}
+
+// CgroupStats is the resource usage of all the processes of a test run, including the
+// ones that left the process group of their launcher. MemoryPeak is only set when the
+// memory controller is enabled for the cgroup.
+type CgroupStats struct {
+	Path       string
+	CPUTime    time.Duration
+	UserTime   time.Duration
+	SystemTime time.Duration
+	MemoryPeak uint64 `json:",omitempty"`
+}
+
+// cgroupKillTimeout is how long the processes of a cgroup get to exit once they were
+// killed.
+const cgroupKillTimeout = 5 * time.Second
@@ -0,0 +1,221 @@
+//go:build linux
+// +build linux
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroup is a cgroup v2 that contains all the processes started for a run of a test.
+type cgroup struct {
+	path string
+}
+
+// newCgroup creates a cgroup for a run of a test inside parent, or inside the cgroup of
+// the runner when parent is empty. The runner needs to be allowed to create cgroups
+// there, which usually means running as root or in a cgroup delegated to its user.
+func newCgroup(parent string) (*cgroup, error) {
+	if parent == "" {
+		var err error
+		if parent, err = currentCgroup(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.procs")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 folder: %s", parent, err)
+	}
+	// the controllers are only needed for the accounting and cannot be enabled for cgroups
+	// that have processes of their own, the cgroup is still used without them.
+	for _, controller := range []string{"+memory", "+pids"} {
+		_ = writeCgroupFile(parent, "cgroup.subtree_control", controller)
+	}
+	dir, err := ioutil.TempDir(parent, "go-atomic-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cgroup: %s", err)
+	}
+	return &cgroup{path: dir}, nil
+}
+
+// currentCgroup returns the folder of the cgroup v2 of the runner.
+func currentCgroup() (string, error) {
+	mount, err := cgroupMount()
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("unable to find cgroup: %s", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Join(mount, line[len("0::"):]), nil
+		}
+	}
+	return "", fmt.Errorf("the runner is not in a cgroup v2")
+}
+
+// cgroupMount returns where the cgroup v2 hierarchy is mounted, /sys/fs/cgroup on most
+// systems and /sys/fs/cgroup/unified on hybrid ones.
+func cgroupMount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", fmt.Errorf("unable to find cgroup v2 mount: %s", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the optional fields end with a single dash, followed by the filesystem type
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && i > 4 {
+				if fields[i+1] == "cgroup2" {
+					return fields[4], nil
+				}
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("unable to find cgroup v2 mount: %s", err)
+	}
+	return "", fmt.Errorf("cgroup v2 is not mounted")
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// add moves a process to the cgroup. The processes it starts afterwards are created in
+// the cgroup, whether they stay in its process group or not.
+func (cg *cgroup) add(pid int) error {
+	if err := writeCgroupFile(cg.path, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("unable to move process %d to cgroup: %s", pid, err)
+	}
+	return nil
+}
+
+// pids returns the processes in the cgroup.
+func (cg *cgroup) pids() ([]int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list processes of cgroup: %s", err)
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// kill kills all the processes in the cgroup and waits for them to exit. Kernels older
+// than 5.14 have no cgroup.kill, the processes are then killed one by one until none is
+// left.
+func (cg *cgroup) kill() error {
+	if cg == nil {
+		return nil
+	}
+	deadline := time.Now().Add(cgroupKillTimeout)
+	for {
+		pids, err := cg.pids()
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d processes of cgroup %s did not exit after they were killed", len(pids), cg.path)
+		}
+		if err := writeCgroupFile(cg.path, "cgroup.kill", "1"); err != nil {
+			for _, pid := range pids {
+				_ = syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// processes returns the processes in the cgroup with their command line.
+func (cg *cgroup) processes() ([]ProcessInfo, error) {
+	pids, err := cg.pids()
+	if err != nil {
+		return nil, err
+	}
+	var processes []ProcessInfo
+	for _, pid := range pids {
+		processes = append(processes, ProcessInfo{PID: pid, Command: processCommand(pid)})
+	}
+	return processes, nil
+}
+
+// processCommand returns the command line of a process, or its name in brackets like ps
+// when it has none.
+func processCommand(pid int) string {
+	proc := filepath.Join("/proc", strconv.Itoa(pid))
+	if data, err := ioutil.ReadFile(filepath.Join(proc, "cmdline")); err == nil && len(data) > 0 {
+		return string(bytes.Join(bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}), []byte(" ")))
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(proc, "comm")); err == nil {
+		return "[" + strings.TrimSpace(string(data)) + "]"
+	}
+	return ""
+}
+
+// stats returns the resource usage of the cgroup.
+func (cg *cgroup) stats() (*CgroupStats, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cg.path, "cpu.stat"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cgroup stats: %s", err)
+	}
+	stats := &CgroupStats{Path: cg.path}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		usec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "usage_usec":
+			stats.CPUTime = time.Duration(usec) * time.Microsecond
+		case "user_usec":
+			stats.UserTime = time.Duration(usec) * time.Microsecond
+		case "system_usec":
+			stats.SystemTime = time.Duration(usec) * time.Microsecond
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(cg.path, "memory.peak")); err == nil {
+		stats.MemoryPeak, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	return stats, nil
+}
+
+// remove removes the cgroup, it has to be empty.
+func (cg *cgroup) remove() error {
+	if err := os.Remove(cg.path); err != nil {
+		return fmt.Errorf("unable to remove cgroup: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package runner
+
+import "fmt"
+
+type cgroup struct{}
+
+func newCgroup(parent string) (*cgroup, error) {
+	return nil, fmt.Errorf("cgroups are only supported on linux")
+}
+
+func (cg *cgroup) add(pid int) error {
+	return nil
+}
+
+func (cg *cgroup) kill() error {
+	return nil
+}
+
+func (cg *cgroup) processes() ([]ProcessInfo, error) {
+	return nil, nil
+}
+
+func (cg *cgroup) stats() (*CgroupStats, error) {
+	return nil, nil
+}
+
+func (cg *cgroup) remove() error {
+	return nil
+}
@@ -1,34 +1,80 @@
 package runner
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-
-	"github.com/ejohn/go-atomic/art"
 )
 
-func runCommands(ctx context.Context, launcher []string, commands string, splitCmds bool) ([]CmdRunInfo, error) {
+// commandConfig controls how the commands of one phase of a test are run.
+type commandConfig struct {
+	splitCmds bool
+	phase     RunPhase
+	output    *outputSink
+	// stdoutLimit and stderrLimit are the most bytes of each stream kept in the result,
+	// zero keeps everything.
+	stdoutLimit int64
+	stderrLimit int64
+	spillDir    string
+	// target is where the launcher is started, the local host when it is nil.
+	target Target
+	// timeout applies to all the commands of the phase, zero means no timeout.
+	timeout     time.Duration
+	termination terminationPolicy
+	launch      launchConfig
+}
+
+// launchConfig is how the launcher process is started.
+type launchConfig struct {
+	// identity is the account the launcher runs as, nil runs it as the current user.
+	identity *identity
+	// env and dir are inherited from the runner when they are not set.
+	env    []string
+	dir    string
+	limits *Limits
+	// cgroup contains the launcher and everything it starts, even processes that leave
+	// its process group. It is killed with the launcher.
+	cgroup *cgroup
+	// isolation starts the launcher in new namespaces, shared are the folders that stay
+	// writable and shared with the host when its root is isolated, and root is the folder
+	// that keeps the changes made to an isolated root.
+	isolation *Isolation
+	shared    []string
+	root      string
+}
+
+// terminationPolicy is how commands are stopped when they time out or are canceled.
+type terminationPolicy struct {
+	signal      syscall.Signal
+	gracePeriod time.Duration
+}
+
+func runCommands(ctx context.Context, launcher []string, commands string, cc commandConfig) ([]CmdRunInfo, error) {
 	if commands == "" {
 		return nil, fmt.Errorf("no commands provided")
 	}
 	var result *CmdResult
 	var cri []CmdRunInfo
 	var cmdErr error
+	if cc.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cc.timeout)
+		defer cancel()
+	}
 
-	if splitCmds {
+	if cc.splitCmds {
 		commandLines := strings.Split(commands, "\n")
 		for _, command := range commandLines {
 			command = strings.TrimSpace(command)
-			// TODO: timeouts are applied per command instead of the whole test. change this.
-			result, cmdErr = runCommand(ctx, launcher, command)
+			result, cmdErr = runCommand(ctx, launcher, command, cc)
 			cri = append(cri, CmdRunInfo{
 				Command: command,
 				Result:  result,
@@ -41,7 +87,7 @@ func runCommands(ctx context.Context, launcher []string, commands string, splitC
 		return cri, cmdErr
 	}
 
-	result, cmdErr = runCommand(ctx, launcher, commands)
+	result, cmdErr = runCommand(ctx, launcher, commands, cc)
 	cri = append(cri, CmdRunInfo{
 		Command: commands,
 		Result:  result,
@@ -49,125 +95,228 @@ func runCommands(ctx context.Context, launcher []string, commands string, splitC
 	return cri, cmdErr
 }
 
-func getPipes(cmd *exec.Cmd) (io.WriteCloser, io.ReadCloser, io.ReadCloser, error) {
+// getPipes connects the standard streams of cmd to pipes. The output pipes are created
+// here instead of with StdoutPipe so that Wait does not close them while output is still
+// being read. The returned files are the ends used by the child process and have to be
+// closed once it has started.
+func getPipes(cmd *exec.Cmd) (io.WriteCloser, *os.File, *os.File, []*os.File, error) {
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
-	stdout, err := cmd.StdoutPipe()
+	stdout, stdoutW, err := os.Pipe()
 	if err != nil {
-		return nil, nil, nil, err
+		stdin.Close()
+		return nil, nil, nil, nil, err
 	}
 
-	stderr, err := cmd.StderrPipe()
+	stderr, stderrW, err := os.Pipe()
 	if err != nil {
-		return nil, nil, nil, err
+		stdin.Close()
+		closeFiles(stdout, stdoutW)
+		return nil, nil, nil, nil, err
+	}
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+	return stdin, stdout, stderr, []*os.File{stdoutW, stderrW}, nil
+}
+
+func closeFiles(files ...*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// outputDrainTimeout is how long output is still waited for after the launcher exited when
+// the readers make no progress. Processes left running in the background can keep the pipes
+// open, their output is not waited for.
+const outputDrainTimeout = 500 * time.Millisecond
+
+// copyBufferSize is the size of the blocks output is read in.
+const copyBufferSize = 32 << 10
+
+// readActivity tracks the progress of the output readers, so that output still buffered in
+// the pipes or being passed to a slow output handler is not cut off.
+type readActivity struct {
+	// blocks is incremented for every block read.
+	blocks int64
+	// writing is the number of readers passing a block on.
+	writing int32
+}
+
+// idleSince reports whether no block was read since blocks was last seen and no reader is
+// busy with a block. It returns the current block count.
+func (ra *readActivity) idleSince(blocks int64) (int64, bool) {
+	current := atomic.LoadInt64(&ra.blocks)
+	return current, current == blocks && atomic.LoadInt32(&ra.writing) == 0
+}
+
+// readOutput copies the output of a stream to w in blocks until the stream is closed.
+func readOutput(w io.Writer, r io.Reader, activity *readActivity) {
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			atomic.AddInt32(&activity.writing, 1)
+			atomic.AddInt64(&activity.blocks, 1)
+			_, writeErr := w.Write(buf[:n])
+			atomic.AddInt32(&activity.writing, -1)
+			if writeErr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// drainOutput waits for the readers to finish once the launcher exited. The pipes are closed
+// when the readers made no progress for outputDrainTimeout, which happens when processes
+// started in the background inherited them.
+func drainOutput(lProcess launcherProcess, activity *readActivity, readersDone <-chan struct{}) {
+	blocks, _ := activity.idleSince(0)
+	timer := time.NewTimer(outputDrainTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-readersDone:
+			return
+		case <-timer.C:
+			var idle bool
+			if blocks, idle = activity.idleSince(blocks); idle {
+				lProcess.closeOutput()
+				<-readersDone
+				return
+			}
+			timer.Reset(outputDrainTimeout)
+		}
 	}
-	return stdin, stdout, stderr, nil
 }
 
 // runCommand runs command using the provided launcher. It returns the combined output
-// on stdout and stderr along with an exitcode
-func runCommand(ctx context.Context, launcher []string, command string) (*CmdResult, error) {
-	lProcess, err := startLauncher(launcher)
+// on stdout and stderr along with an exitcode. Output is also sent to the output sink
+// of cc as it is produced.
+func runCommand(ctx context.Context, launcher []string, command string, cc commandConfig) (*CmdResult, error) {
+	prefix := "go-atomic"
+	if cc.phase != "" {
+		prefix += "-" + string(cc.phase)
+	}
+	stdoutBuf, err := newCapture(cc.stdoutLimit, cc.spillDir, prefix+"-stdout")
+	if err != nil {
+		return nil, err
+	}
+	stderrBuf, err := newCapture(cc.stderrLimit, cc.spillDir, prefix+"-stderr")
+	if err != nil {
+		stdoutBuf.close()
+		return nil, err
+	}
+	lProcess, err := targetOf(cc.target).start(launcher, cc.launch)
 	if err != nil {
+		stdoutBuf.close()
+		stderrBuf.close()
 		return nil, err
 	}
 	startTime := time.Now()
+	stdin, stdout, stderr := lProcess.pipes()
 	go func() {
-		defer lProcess.stdin.Close()
-		_, _ = io.WriteString(lProcess.stdin, command)
+		defer stdin.Close()
+		_, _ = io.WriteString(stdin, command)
 		// send new line to ensure executor starts executing the command
-		_, _ = io.WriteString(lProcess.stdin, "\n")
+		_, _ = io.WriteString(stdin, "\n")
 	}()
 
-	pid := lProcess.cmd.Process.Pid
+	pid := lProcess.pid()
 
-	var stdoutBuf bytes.Buffer
+	var readers sync.WaitGroup
+	var activity readActivity
+	readers.Add(2)
+	stdoutSink := cc.output.writer(cc.phase, command, pid, Stdout)
 	go func() {
-		defer lProcess.stdout.Close()
-		scanner := bufio.NewScanner(lProcess.stdout)
-		scanner.Split(bufio.ScanBytes)
-		for scanner.Scan() {
-			stdoutBuf.Write(scanner.Bytes())
-		}
+		defer readers.Done()
+		defer stdoutSink.Close()
+		readOutput(io.MultiWriter(stdoutBuf, stdoutSink), stdout, &activity)
 	}()
-
-	var stderrBuf bytes.Buffer
+	stderrSink := cc.output.writer(cc.phase, command, pid, Stderr)
 	go func() {
-		defer lProcess.stderr.Close()
-		scanner := bufio.NewScanner(lProcess.stderr)
-		scanner.Split(bufio.ScanBytes)
-		for scanner.Scan() {
-			stderrBuf.Write(scanner.Bytes())
-		}
+		defer readers.Done()
+		defer stderrSink.Close()
+		readOutput(io.MultiWriter(stderrBuf, stderrSink), stderr, &activity)
+	}()
+	readersDone := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(readersDone)
 	}()
-	var exitCode int
-	var cmdErr error
-	cmdDone := make(chan struct{})
 
+	var status exitStatus
+	var waitErr error
+	cmdDone := make(chan struct{})
 	go func() {
-		if cmdErr = lProcess.cmd.Wait(); cmdErr != nil {
-			if exitErr, ok := cmdErr.(*exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-					exitCode = status.ExitStatus()
-				}
-			}
-		}
+		status, waitErr = lProcess.wait()
 		close(cmdDone)
 	}()
 
+	var cmdErr error
+	killed := false
 	select {
 	case <-ctx.Done():
-		killLauncher(lProcess)
+		lProcess.terminate(cc.termination, cmdDone)
+		_ = cc.launch.cgroup.kill()
+		<-cmdDone
+		killed = true
 		cmdErr = fmt.Errorf("command timed out")
-		exitCode = -1
+		if ctx.Err() == context.Canceled {
+			cmdErr = fmt.Errorf("command canceled")
+		}
+		status.code = -1
 	case <-cmdDone:
+		cmdErr = waitErr
 	}
 
+	// the output is complete once both pipes are closed, which can be delayed by
+	// processes started in the background that inherited them.
+	drainOutput(lProcess, &activity, readersDone)
+	lProcess.closeOutput()
+
 	res := &CmdResult{
-		PID:       pid,
-		Stdout:    stdoutBuf.String(),
-		Stderr:    stderrBuf.String(),
-		ExitCode:  exitCode,
-		StartTime: startTime,
-		EndTime:   time.Now(),
+		PID:                pid,
+		Stdout:             stdoutBuf.String(),
+		Stderr:             stderrBuf.String(),
+		ExitCode:           status.code,
+		Signal:             status.signal,
+		Killed:             killed,
+		StartTime:          startTime,
+		EndTime:            time.Now(),
+		StdoutDroppedBytes: stdoutBuf.dropped(),
+		StderrDroppedBytes: stderrBuf.dropped(),
+		StdoutFile:         stdoutBuf.close(),
+		StderrFile:         stderrBuf.close(),
 	}
+	res.StdoutTruncated = res.StdoutDroppedBytes > 0
+	res.StderrTruncated = res.StderrDroppedBytes > 0
+	res.LimitsHit = limitsHit(cc.launch.limits, res)
 	return res, cmdErr
 }
 
-func buildArguments(defaultArgs map[string]art.Argument, args map[string]string, atomicsFolder string) map[string]string {
-	combined := make(map[string]string)
-	for k, v := range defaultArgs {
-		combined[k] = v.Default
-	}
-	for k, v := range args {
-		// skip user supplied args unless they are specified in the atomic test
-		if _, found := defaultArgs[k]; !found {
-			continue
-		}
-		combined[k] = v
-	}
-	for k := range combined {
-		arg := strings.ReplaceAll(combined[k], "$PathToAtomicsFolder", atomicsFolder)
-		arg = strings.ReplaceAll(arg, "PathToAtomicsFolder", atomicsFolder)
-		combined[k] = arg
-	}
-	return combined
+// placeholderPatterns match the input argument placeholders in commands. The argument
+// name is the first submatch.
+var placeholderPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`#\{([a-zA-Z0-9_]+)\}`),
+	regexp.MustCompile(`\$\{([a-zA-Z0-9_]+)\}`),
 }
 
 func buildCommands(commandTemplate string, arguments map[string]string, atomicsFolder string) (string, error) {
 	if commandTemplate == "" {
 		return "", nil
 	}
-	command, err := replacePlaceholder(`#\{([a-zA-Z0-9_]+)\}`, commandTemplate, arguments)
-	if err != nil {
-		return "", err
-	}
-	command, err = replacePlaceholder(`\$\{([a-zA-Z0-9_]+)\}`, command, arguments)
-	if err != nil {
-		return "", err
+	command := commandTemplate
+	var err error
+	for _, pattern := range placeholderPatterns {
+		command, err = replacePlaceholder(pattern, command, arguments)
+		if err != nil {
+			return "", err
+		}
 	}
 	command = strings.ReplaceAll(command, "$PathToAtomicsFolder", atomicsFolder)
 	command = strings.ReplaceAll(command, "PathToAtomicsFolder", atomicsFolder)
@@ -175,9 +324,8 @@ func buildCommands(commandTemplate string, arguments map[string]string, atomicsF
 	return strings.TrimSpace(command), err
 }
 
-func replacePlaceholder(re, commandTemplate string, arguments map[string]string) (string, error) {
-	pattern1 := regexp.MustCompile(re)
-	matches := pattern1.FindAllSubmatch([]byte(commandTemplate), -1)
+func replacePlaceholder(pattern *regexp.Regexp, commandTemplate string, arguments map[string]string) (string, error) {
+	matches := pattern.FindAllSubmatch([]byte(commandTemplate), -1)
 
 	_command := commandTemplate
 	for _, match := range matches {
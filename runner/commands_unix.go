@@ -1,21 +1,29 @@
+//go:build !windows
 // +build !windows
 
 package runner
 
 import (
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 type launchProc struct {
 	cmd    *exec.Cmd
 	stdin  io.WriteCloser
-	stdout io.ReadCloser
-	stderr io.ReadCloser
+	stdout *os.File
+	stderr *os.File
 }
 
-func startLauncher(launcher []string) (*launchProc, error) {
+func startLauncher(launcher []string, lc launchConfig) (*launchProc, error) {
 	lp := &launchProc{}
 	if len(launcher) > 1 {
 		lp.cmd = exec.Command(launcher[0], launcher[1:]...)
@@ -24,22 +32,101 @@ func startLauncher(launcher []string) (*launchProc, error) {
 	}
 	// create new process group for launcher
 	lp.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if id := lc.identity; id != nil {
+		lp.cmd.SysProcAttr.Credential = &syscall.Credential{Uid: id.uid, Gid: id.gid, Groups: id.groups}
+	}
+	lp.cmd.Env, lp.cmd.Dir = lc.env, lc.dir
+	if lc.isolation != nil {
+		if err := isolateLauncher(lp.cmd, lc); err != nil {
+			return nil, err
+		}
+	}
 
 	var err error
-	lp.stdin, lp.stdout, lp.stderr, err = getPipes(lp.cmd)
+	var childFiles []*os.File
+	lp.stdin, lp.stdout, lp.stderr, childFiles, err = getPipes(lp.cmd)
 	if err != nil {
 		return nil, err
 	}
 
 	err = lp.cmd.Start()
+	closeFiles(childFiles...)
 	if err != nil {
+		closeFiles(lp.stdout, lp.stderr)
 		return nil, err
 	}
 
+	// the launcher waits for its commands on stdin, so the cgroup and the limits are in
+	// place before it starts any other process.
+	abort := func(err error) (*launchProc, error) {
+		_ = syscall.Kill(-lp.cmd.Process.Pid, syscall.SIGKILL)
+		_ = lp.stdin.Close()
+		_ = lp.cmd.Wait()
+		closeFiles(lp.stdout, lp.stderr)
+		return nil, err
+	}
+	if lc.cgroup != nil {
+		if err := lc.cgroup.add(lp.cmd.Process.Pid); err != nil {
+			return abort(err)
+		}
+	}
+	if !lc.limits.isZero() {
+		if err := setLimits(lp.cmd.Process.Pid, lc.limits); err != nil {
+			return abort(err)
+		}
+	}
+
 	return lp, nil
 }
 
-func killLauncher(lp *launchProc) {
-	// kill all processes in the process group
-	_ = syscall.Kill(-lp.cmd.Process.Pid, syscall.SIGKILL)
+// terminateLauncher sends the signal of the policy to all the processes in the process
+// group of the launcher and kills the ones still running after the grace period. The group
+// gets the whole grace period even when the launcher exits right away, so that the processes
+// running the commands can still flush their output or undo partial changes.
+func terminateLauncher(lp *launchProc, tp terminationPolicy, exited <-chan struct{}) {
+	pgid := -lp.cmd.Process.Pid
+	if tp.signal != 0 && tp.signal != syscall.SIGKILL {
+		_ = syscall.Kill(pgid, tp.signal)
+		waitForGroup(pgid, tp.gracePeriod)
+	}
+	_ = syscall.Kill(pgid, syscall.SIGKILL)
+}
+
+// groupPollInterval is how often the process group is checked while it gets to exit.
+const groupPollInterval = 20 * time.Millisecond
+
+// waitForGroup waits until no process is left in the process group or the timeout expired.
+func waitForGroup(pgid int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pgid, 0); err == syscall.ESRCH {
+			return
+		}
+		time.Sleep(groupPollInterval)
+	}
+}
+
+// signalName returns the name of a signal like SIGTERM.
+func signalName(sig syscall.Signal) string {
+	if name := unix.SignalName(sig); name != "" {
+		return name
+	}
+	return sig.String()
+}
+
+// ParseSignal parses a signal name like SIGTERM or TERM, or a signal number.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(name); err == nil && num > 0 {
+		return syscall.Signal(num), nil
+	}
+	want := strings.ToUpper(name)
+	if !strings.HasPrefix(want, "SIG") {
+		want = "SIG" + want
+	}
+	for sig := syscall.Signal(1); sig < 65; sig++ {
+		if unix.SignalName(sig) == want {
+			return sig, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signal %q", name)
 }
@@ -1,3 +1,4 @@
+//go:build windows
 // +build windows
 
 package runner
@@ -5,7 +6,9 @@ package runner
 import (
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"syscall"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -15,8 +18,8 @@ type launchProc struct {
 	cmd    *exec.Cmd
 	job    windows.Handle
 	stdin  io.WriteCloser
-	stdout io.ReadCloser
-	stderr io.ReadCloser
+	stdout *os.File
+	stderr *os.File
 }
 
 // links to documentation on JobObject which is used to ensure child processes
@@ -46,7 +49,16 @@ func createJobObject() (windows.Handle, error) {
 	return job, nil
 }
 
-func startLauncher(launcher []string) (*launchProc, error) {
+func startLauncher(launcher []string, lc launchConfig) (*launchProc, error) {
+	if lc.identity != nil {
+		return nil, fmt.Errorf("running commands as another user is not supported on windows")
+	}
+	if !lc.limits.isZero() {
+		return nil, setLimits(0, lc.limits)
+	}
+	if lc.isolation != nil {
+		return nil, isolateLauncher(nil, lc)
+	}
 	var err error
 	lp := &launchProc{}
 	lp.job, err = createJobObject()
@@ -59,15 +71,19 @@ func startLauncher(launcher []string) (*launchProc, error) {
 	} else {
 		lp.cmd = exec.Command(launcher[0])
 	}
+	lp.cmd.Env, lp.cmd.Dir = lc.env, lc.dir
 
-	lp.stdin, lp.stdout, lp.stderr, err = getPipes(lp.cmd)
+	var childFiles []*os.File
+	lp.stdin, lp.stdout, lp.stderr, childFiles, err = getPipes(lp.cmd)
 	if err != nil {
 		_ = windows.CloseHandle(lp.job)
 		return nil, err
 	}
 
 	err = lp.cmd.Start()
+	closeFiles(childFiles...)
 	if err != nil {
+		closeFiles(lp.stdout, lp.stderr)
 		_ = windows.CloseHandle(lp.job)
 		return nil, err
 	}
@@ -89,7 +105,18 @@ func startLauncher(launcher []string) (*launchProc, error) {
 	return lp, nil
 }
 
-func killLauncher(lp *launchProc) {
+// terminateLauncher kills all the processes of the launcher. Windows has no signals, so
+// the termination policy is not used.
+func terminateLauncher(lp *launchProc, tp terminationPolicy, exited <-chan struct{}) {
 	// closing the handle kills all the processes that were created inside the job.
 	_ = windows.CloseHandle(lp.job)
 }
+
+func signalName(sig syscall.Signal) string {
+	return sig.String()
+}
+
+// ParseSignal is not supported on windows.
+func ParseSignal(name string) (syscall.Signal, error) {
+	return 0, fmt.Errorf("signals are not supported on windows")
+}
@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Environment controls the environment variables and the working directory of the
+// commands of a test. The zero value runs commands with the environment and the working
+// directory of the runner.
+type Environment struct {
+	// Vars are set for the commands, replacing inherited variables with the same name.
+	Vars map[string]string
+	// Clean runs the commands without inheriting any variable. When Clean is not set and
+	// Allowlist is not empty, only the variables in Allowlist are inherited.
+	Clean     bool
+	Allowlist []string
+	// Dir is the working directory, relative paths are resolved against the working
+	// directory of the runner.
+	Dir string
+}
+
+func (e *Environment) isZero() bool {
+	return e == nil || (len(e.Vars) == 0 && !e.Clean && len(e.Allowlist) == 0 && e.Dir == "")
+}
+
+// merge returns e with the settings of override applied on top. Variables are merged, the
+// other settings are replaced when they are set in override.
+func (e Environment) merge(override *Environment) Environment {
+	if override == nil {
+		return e
+	}
+	merged := e
+	merged.Vars = make(map[string]string, len(e.Vars)+len(override.Vars))
+	for name, value := range e.Vars {
+		merged.Vars[name] = value
+	}
+	for name, value := range override.Vars {
+		merged.Vars[name] = value
+	}
+	if override.Clean {
+		merged.Clean, merged.Allowlist = true, nil
+	} else if len(override.Allowlist) > 0 {
+		merged.Clean, merged.Allowlist = false, override.Allowlist
+	}
+	if override.Dir != "" {
+		merged.Dir = override.Dir
+	}
+	return merged
+}
+
+// PhaseEnvironment is the environment the commands of a phase were started with. Only what
+// the runner set is recorded, inherited variables are listed by name so that the secrets in
+// the environment of the runner do not end up in the results.
+type PhaseEnvironment struct {
+	Dir string `json:",omitempty"`
+	// Vars are the variables set by the runner, including the ones of the account the
+	// commands run as.
+	Vars map[string]string `json:",omitempty"`
+	// Clean and Allowlist are how variables were inherited, Inherited are their names.
+	Clean     bool     `json:",omitempty"`
+	Allowlist []string `json:",omitempty"`
+	Inherited []string `json:",omitempty"`
+
+	// env is what the commands are started with.
+	env []string
+}
+
+// environment returns what the commands of a phase are started with. A nil environment
+// inherits everything from the runner.
+func (e Environment) environment(id *identity) (*PhaseEnvironment, error) {
+	if e.isZero() && id == nil {
+		return nil, nil
+	}
+	pe := &PhaseEnvironment{Clean: e.Clean}
+	if e.Dir != "" {
+		dir, err := filepath.Abs(e.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("working directory %s: %s", e.Dir, err)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("working directory %s: %s", e.Dir, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("working directory %s is not a directory", e.Dir)
+		}
+		pe.Dir = dir
+	}
+
+	inherited := make(map[string]string)
+	if !e.Clean {
+		pe.Allowlist = e.Allowlist
+		allowed := make(map[string]bool)
+		for _, name := range e.Allowlist {
+			allowed[name] = true
+		}
+		for _, variable := range os.Environ() {
+			name, value := splitVariable(variable)
+			if len(allowed) == 0 || allowed[name] {
+				inherited[name] = value
+			}
+		}
+	}
+	set := make(map[string]string)
+	if id != nil {
+		set["HOME"], set["USER"], set["LOGNAME"] = id.home, id.username, id.username
+	}
+	for name, value := range e.Vars {
+		set[name] = value
+	}
+	for name, value := range inherited {
+		if _, found := set[name]; !found {
+			pe.Inherited = append(pe.Inherited, name)
+			pe.env = append(pe.env, name+"="+value)
+		}
+	}
+	if len(set) > 0 {
+		pe.Vars = set
+	}
+	for name, value := range set {
+		pe.env = append(pe.env, name+"="+value)
+	}
+	sort.Strings(pe.Inherited)
+	sort.Strings(pe.env)
+	return pe, nil
+}
+
+func splitVariable(variable string) (string, string) {
+	if variable == "" {
+		return "", ""
+	}
+	// windows has variables like =C: that start with the separator
+	if index := strings.Index(variable[1:], "="); index >= 0 {
+		return variable[:index+1], variable[index+2:]
+	}
+	return variable, ""
+}
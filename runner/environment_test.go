@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironment_Merge(t *testing.T) {
+	base := Environment{Vars: map[string]string{"A": "1", "B": "2"}, Allowlist: []string{"PATH"}, Dir: "base"}
+	merged := base.merge(&Environment{Vars: map[string]string{"B": "3"}, Clean: true})
+	assert.Equal(t, map[string]string{"A": "1", "B": "3"}, merged.Vars)
+	assert.True(t, merged.Clean)
+	assert.Nil(t, merged.Allowlist)
+	assert.Equal(t, "base", merged.Dir)
+	// the base environment is not modified
+	assert.Equal(t, "2", base.Vars["B"])
+
+	merged = base.merge(&Environment{Dir: "phase"})
+	assert.Equal(t, []string{"PATH"}, merged.Allowlist)
+	assert.Equal(t, "phase", merged.Dir)
+	assert.Equal(t, base, base.merge(nil))
+}
+
+func TestEnvironment_Environment(t *testing.T) {
+	pe, err := Environment{}.environment(nil)
+	require.NoError(t, err)
+	assert.Nil(t, pe)
+
+	pe, err = Environment{Clean: true, Vars: map[string]string{"B": "2", "A": "1"}}.environment(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A=1", "B=2"}, pe.env)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, pe.Vars)
+	assert.Nil(t, pe.Inherited)
+	assert.Equal(t, "", pe.Dir)
+
+	require.NoError(t, os.Setenv("GO_ATOMIC_ALLOWED", "yes"))
+	defer os.Unsetenv("GO_ATOMIC_ALLOWED")
+	pe, err = Environment{Allowlist: []string{"GO_ATOMIC_ALLOWED"}}.environment(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GO_ATOMIC_ALLOWED=yes"}, pe.env)
+	assert.Equal(t, []string{"GO_ATOMIC_ALLOWED"}, pe.Inherited)
+	assert.Nil(t, pe.Vars)
+
+	pe, err = Environment{Clean: true}.environment(&identity{username: "atomic", home: "/home/atomic"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HOME=/home/atomic", "LOGNAME=atomic", "USER=atomic"}, pe.env)
+
+	dir := t.TempDir()
+	pe, err = Environment{Dir: dir}.environment(nil)
+	require.NoError(t, err)
+	assert.Equal(t, dir, pe.Dir)
+	assert.Contains(t, pe.env, "GO_ATOMIC_ALLOWED=yes")
+	// only the names of inherited variables are recorded
+	assert.Contains(t, pe.Inherited, "GO_ATOMIC_ALLOWED")
+	assert.Nil(t, pe.Vars)
+	content, err := json.Marshal(pe)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "yes")
+
+	_, err = Environment{Dir: dir + "/missing"}.environment(nil)
+	assert.Error(t, err)
+}
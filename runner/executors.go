@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// LauncherFactory returns the command line of the program that runs the commands of an
+// executor. The commands are written to the standard input of the program.
+//
+// Factories run on the host of the runner. When the commands run on another target, the
+// program is looked up by its name in the PATH of the target.
+type LauncherFactory func() ([]string, error)
+
+// builtinLauncher returns the launcher of a built-in executor on target.
+type builtinLauncher func(target Target) ([]string, error)
+
+// builtinExecutors are the executors every runner supports.
+var builtinExecutors = map[string]builtinLauncher{
+	"command_prompt": getCMDPromptForPlatform,
+	"powershell":     getPowerShellLauncher,
+	"sh":             fixedLauncher("/bin/sh"),
+	"bash":           fixedLauncher("/bin/bash"),
+	"zsh":            pathLauncher("zsh"),
+	"python3":        pathLauncher("python3", "-"),
+	"pwsh":           pathLauncher("pwsh", "-Command", "-"),
+	manualExecutor: func(Target) ([]string, error) {
+		return nil, fmt.Errorf("test with manual executor cannot be run")
+	},
+}
+
+// fixedLauncher returns a launcher that does not depend on the target.
+func fixedLauncher(launcher ...string) builtinLauncher {
+	return func(Target) ([]string, error) {
+		return launcher, nil
+	}
+}
+
+// pathLauncher returns a launcher whose program is looked up in the PATH of the target
+// when a test is built.
+func pathLauncher(program string, args ...string) builtinLauncher {
+	return func(target Target) ([]string, error) {
+		path, err := target.lookPath(program)
+		if err != nil {
+			return nil, err
+		}
+		return append([]string{path}, args...), nil
+	}
+}
+
+// RegisterExecutor makes the executor name available to tests, replacing the built-in
+// executor with the same name if there is one. Executors have to be registered before
+// tests are built or run.
+func (ar *Runner) RegisterExecutor(name string, factory LauncherFactory) {
+	if ar.executors == nil {
+		ar.executors = make(map[string]LauncherFactory)
+	}
+	ar.executors[name] = factory
+}
+
+// Executors returns the names of the executors the runner supports, sorted by name.
+func (ar *Runner) Executors() []string {
+	var names []string
+	for name := range builtinExecutors {
+		if _, found := ar.executors[name]; !found {
+			names = append(names, name)
+		}
+	}
+	for name := range ar.executors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// supportsExecutor reports whether name is a built-in or registered executor.
+func (ar *Runner) supportsExecutor(name string) bool {
+	if _, found := ar.executors[name]; found {
+		return true
+	}
+	_, found := builtinExecutors[name]
+	return found
+}
+
+// launcher returns the launcher of a registered or built-in executor on target.
+func (ar *Runner) launcher(executorName string, target Target) ([]string, error) {
+	if executorName == "" {
+		return nil, fmt.Errorf("executor name not provided")
+	}
+	factory, found := ar.executors[executorName]
+	if !found {
+		return targetLauncher(executorName, target)
+	}
+	launcher, err := factory()
+	if err != nil {
+		return nil, err
+	}
+	if len(launcher) == 0 {
+		return nil, fmt.Errorf("executor %q has no launcher", executorName)
+	}
+	if isLocal(target) {
+		return launcher, nil
+	}
+	program, err := target.lookPath(path.Base(filepath.ToSlash(launcher[0])))
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{program}, launcher[1:]...), nil
+}
+
+// getLauncher returns the launcher of a built-in executor on the host of the runner.
+func getLauncher(executorName string) ([]string, error) {
+	return targetLauncher(executorName, LocalTarget{})
+}
+
+// targetLauncher returns the launcher of a built-in executor on target.
+func targetLauncher(executorName string, target Target) ([]string, error) {
+	if executorName == "" {
+		return nil, fmt.Errorf("executor name not provided")
+	}
+	launcher, found := builtinExecutors[executorName]
+	if !found {
+		return nil, fmt.Errorf("executor %q is not supported", executorName)
+	}
+	return launcher(target)
+}
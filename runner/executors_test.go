@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+func TestRunner_Executors(t *testing.T) {
+	ar := Runner{}
+	assert.Equal(t, []string{"bash", "command_prompt", "manual", "powershell", "pwsh", "python3", "sh", "zsh"},
+		ar.Executors())
+
+	ar.RegisterExecutor("fish", launcherFactory("/usr/bin/fish"))
+	ar.RegisterExecutor("sh", launcherFactory("/bin/dash"))
+	assert.Contains(t, ar.Executors(), "fish")
+	assert.Equal(t, 1, countOf(ar.Executors(), "sh"))
+
+	launcher, err := ar.launcher("sh", LocalTarget{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/dash"}, launcher)
+	launcher, err = ar.launcher("bash", LocalTarget{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/bash"}, launcher)
+
+	ar.RegisterExecutor("empty", func() ([]string, error) { return nil, nil })
+	_, err = ar.BuildTest(&art.Test{
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor:           art.Executor{Name: "empty", Command: "echo test"},
+	}, nil)
+	assert.EqualError(t, err, `error getting launcher: executor "empty" has no launcher`)
+
+	_, err = ar.launcher("cobol", LocalTarget{})
+	assert.EqualError(t, err, `executor "cobol" is not supported`)
+	_, err = ar.launcher("", LocalTarget{})
+	assert.Error(t, err)
+}
+
+func TestBuildTest_UnsupportedExecutor(t *testing.T) {
+	test := &art.Test{
+		TechniqueID:            "T9999",
+		Name:                   "Test",
+		SupportedPlatforms:     []string{getCurrentPlatform()},
+		DependencyExecutorName: "fish",
+		Dependencies:           []art.Dependency{{PrereqCommand: "exit 0"}},
+		Executor:               art.Executor{Name: "sh", Command: "echo test"},
+	}
+	ar := Runner{}
+	_, err := ar.BuildTest(test, nil)
+	assert.EqualError(t, err, `failed to build dependency: error getting dependency launcher: executor "fish" is not supported`)
+	assert.Equal(t, SeverityError, LintTest(test)[0].Severity)
+
+	ar.RegisterExecutor("fish", launcherFactory("/usr/bin/fish"))
+	bt, err := ar.BuildTest(test, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/usr/bin/fish"}, bt.DependencyInfo.Launcher)
+	assert.Empty(t, ar.lintTest(test))
+}
+
+func TestPathLauncher(t *testing.T) {
+	_, err := pathLauncher("go-atomic-missing-program")(LocalTarget{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "go-atomic-missing-program was not found in PATH")
+}
+
+func launcherFactory(launcher ...string) LauncherFactory {
+	return func() ([]string, error) {
+		return launcher, nil
+	}
+}
+
+func countOf(values []string, value string) int {
+	count := 0
+	for _, candidate := range values {
+		if candidate == value {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,193 @@
+package runner
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// guidNamespace is the namespace used to derive name based guids for atomic tests.
+var guidNamespace = [16]byte{
+	0x6b, 0x1f, 0x3a, 0x52, 0x0c, 0x4e, 0x4d, 0x8b,
+	0x9a, 0x27, 0x5e, 0x31, 0xd0, 0x7c, 0x42, 0x96,
+}
+
+// GUIDUpdate describes a guid generated for a test that did not have one.
+type GUIDUpdate struct {
+	Path        string
+	TechniqueID string
+	TestName    string
+	Line        int
+	GUID        string
+}
+
+// GenerateGUIDs adds an auto_generated_guid to every test in the technique files inside
+// folder that does not have one. Guids are derived from the technique id, the position and
+// the name of the test, so running it again on the same files generates the same guids.
+// The guid is inserted as a new line after the test name and the rest of the file is left
+// untouched. Files are only modified when write is set.
+func GenerateGUIDs(folder string, write bool) ([]GUIDUpdate, error) {
+	source := &fsSource{fsys: os.DirFS(folder)}
+	files, err := source.yamlFiles()
+	if err != nil {
+		return nil, err
+	}
+	var updates []GUIDUpdate
+	for _, file := range files {
+		path := filepath.Join(folder, filepath.FromSlash(file.path))
+		content, err := file.read()
+		if err != nil {
+			return updates, err
+		}
+		updated, fileUpdates, err := insertGUIDs(content)
+		if err != nil {
+			return updates, fmt.Errorf("%s: %s", path, err)
+		}
+		if len(fileUpdates) == 0 {
+			continue
+		}
+		for index := range fileUpdates {
+			fileUpdates[index].Path = path
+		}
+		updates = append(updates, fileUpdates...)
+		if write {
+			info, err := os.Stat(path)
+			if err != nil {
+				return updates, err
+			}
+			if err := ioutil.WriteFile(path, updated, info.Mode().Perm()); err != nil {
+				return updates, err
+			}
+		}
+	}
+	return updates, nil
+}
+
+// guidInsert is a line to be inserted before the zero based line index.
+type guidInsert struct {
+	index  int
+	indent int
+	guid   string
+}
+
+// insertGUIDs returns content with a guid line added to every test without one. It works
+// on the lines of the file instead of re-encoding the yaml so that comments, quoting and
+// ordering are preserved.
+func insertGUIDs(content []byte) ([]byte, []GUIDUpdate, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, nil, err
+	}
+	if len(root.Content) == 0 {
+		return content, nil, nil
+	}
+	doc := root.Content[0]
+	var techniqueID string
+	if id := mappingValue(doc, "attack_technique"); id != nil {
+		techniqueID = id.Value
+	}
+	tests := mappingValue(doc, "atomic_tests")
+	if tests == nil || tests.Kind != yaml.SequenceNode {
+		return content, nil, nil
+	}
+
+	newline := "\n"
+	if bytes.Contains(content, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	var inserts []guidInsert
+	var updates []GUIDUpdate
+	for index, test := range tests.Content {
+		if test.Kind != yaml.MappingNode || test.Style&yaml.FlowStyle != 0 || len(test.Content) == 0 {
+			continue
+		}
+		if mappingValue(test, "auto_generated_guid") != nil {
+			continue
+		}
+		name := ""
+		if nameNode := mappingValue(test, "name"); nameNode != nil {
+			name = nameNode.Value
+		}
+		// insert after the name, which is where atomic red team puts the guid. When the
+		// test has no name or the value spans several lines, the guid is put before the
+		// key that follows it, or before the next test.
+		anchor := 0
+		for i := 0; i+1 < len(test.Content); i += 2 {
+			if test.Content[i].Value == "name" {
+				anchor = i
+				break
+			}
+		}
+		value := test.Content[anchor+1]
+		lineIndex := len(lines)
+		if value.Kind == yaml.ScalarNode && value.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			lineIndex = value.Line
+		} else if anchor+2 < len(test.Content) {
+			lineIndex = test.Content[anchor+2].Line - 1
+		} else if index+1 < len(tests.Content) {
+			lineIndex = tests.Content[index+1].Line - 1
+		} else if next := nextNodeLine(doc, tests); next > 0 {
+			lineIndex = next - 1
+		}
+		guid := testGUID(techniqueID, index, name)
+		inserts = append(inserts, guidInsert{
+			index:  lineIndex,
+			indent: test.Content[anchor].Column - 1,
+			guid:   guid,
+		})
+		updates = append(updates, GUIDUpdate{
+			TechniqueID: techniqueID,
+			TestName:    name,
+			// the guids of the earlier tests are inserted above this one
+			Line: lineIndex + len(inserts),
+			GUID: guid,
+		})
+	}
+	if len(inserts) == 0 {
+		return content, nil, nil
+	}
+
+	// insert from the bottom so that the earlier line indexes stay valid.
+	sort.SliceStable(inserts, func(i, j int) bool {
+		return inserts[i].index > inserts[j].index
+	})
+	if last := lines[len(lines)-1]; last != "" && !strings.HasSuffix(last, "\n") {
+		lines[len(lines)-1] = last + newline
+	}
+	for _, insert := range inserts {
+		line := strings.Repeat(" ", insert.indent) + "auto_generated_guid: " + insert.guid + newline
+		lines = append(lines[:insert.index], append([]string{line}, lines[insert.index:]...)...)
+	}
+	return []byte(strings.Join(lines, "")), updates, nil
+}
+
+// nextNodeLine returns the line of the key that follows node in the document mapping,
+// or zero if node is the last value.
+func nextNodeLine(doc, node *yaml.Node) int {
+	for i := 1; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i] == node {
+			return doc.Content[i+1].Line
+		}
+	}
+	return 0
+}
+
+// testGUID derives a version 5 (name based) uuid for a test.
+func testGUID(techniqueID string, index int, name string) string {
+	h := sha1.New()
+	h.Write(guidNamespace[:])
+	h.Write([]byte(techniqueID + "\x00" + strconv.Itoa(index) + "\x00" + name))
+	sum := h.Sum(nil)
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertGUIDs(t *testing.T) {
+	testYaml := `---
+attack_technique: T9999 # comment is kept
+display_name: TestData
+
+atomic_tests:
+  - name: Test1
+    auto_generated_guid: 5859a680-2395-40a4-a491-693262ef3b80
+    executor:
+      name: sh
+  - name: "Test2"
+    # comment before the description
+    description: |
+      multi line
+      description
+    executor: {name: sh}
+  - description: name is not the first key
+    name: Test3
+  - executor:
+      name: manual
+    name: Test4`
+	updated, updates, err := insertGUIDs([]byte(testYaml))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(updates))
+	want := `---
+attack_technique: T9999 # comment is kept
+display_name: TestData
+
+atomic_tests:
+  - name: Test1
+    auto_generated_guid: 5859a680-2395-40a4-a491-693262ef3b80
+    executor:
+      name: sh
+  - name: "Test2"
+    auto_generated_guid: ` + updates[0].GUID + `
+    # comment before the description
+    description: |
+      multi line
+      description
+    executor: {name: sh}
+  - description: name is not the first key
+    name: Test3
+    auto_generated_guid: ` + updates[1].GUID + `
+  - executor:
+      name: manual
+    name: Test4
+    auto_generated_guid: ` + updates[2].GUID + `
+`
+	assert.Equal(t, want, string(updated))
+	assert.Equal(t, 11, updates[0].Line)
+	assert.Equal(t, 19, updates[1].Line)
+	assert.Equal(t, 23, updates[2].Line)
+	assert.Equal(t, "Test2", updates[0].TestName)
+
+	// guids are stable and the updated file parses with every test having a guid
+	_, again, err := insertGUIDs([]byte(testYaml))
+	require.NoError(t, err)
+	assert.Equal(t, updates, again)
+	technique, err := parse(updated)
+	require.NoError(t, err)
+	for _, test := range technique.AtomicTests {
+		assert.NotEmpty(t, test.AutoGeneratedGUID)
+	}
+	assert.NotEqual(t, updates[0].GUID, updates[1].GUID)
+
+	// files where all tests have a guid are not changed
+	_, updates, err = insertGUIDs(updated)
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+}
+
+func TestGenerateGUIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlFile := filepath.Join(dir, "T9999", "T9999.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(yamlFile), 0755))
+	content, err := ioutil.ReadFile(filepath.Join(testFolder, "T9999", "T9999.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(yamlFile, content, 0644))
+
+	updates, err := GenerateGUIDs(dir, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(updates))
+	assert.Equal(t, "Test4", updates[0].TestName)
+	assert.Equal(t, yamlFile, updates[0].Path)
+	unchanged, err := ioutil.ReadFile(yamlFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, unchanged)
+
+	_, err = GenerateGUIDs(dir, true)
+	require.NoError(t, err)
+	ar := &Runner{AtomicsFolder: dir}
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	test, err := ar.GetTestByGUID(updates[0].GUID)
+	require.NoError(t, err)
+	assert.Equal(t, "Test4", test.Name)
+}
+
+func TestLoadTechniques_MissingGUIDWarning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlFile := filepath.Join(dir, "T9999", "T9999.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(yamlFile), 0755))
+	require.NoError(t, ioutil.WriteFile(yamlFile, []byte(`---
+attack_technique: T9999
+display_name: Private
+
+atomic_tests:
+  - name: Private Test
+    supported_platforms:
+      - linux
+    executor:
+      name: sh
+      command: echo private
+`), 0644))
+
+	// a missing guid is only a warning, strict loads succeed so that guids can be generated.
+	ar := &Runner{AtomicsFolder: dir, StrictLoad: true}
+	defer ar.Close()
+	report, err := ar.LoadTechniques()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(report.Files))
+	require.Equal(t, 1, len(report.Files[0].Issues))
+	assert.Equal(t, MissingGUIDIssue, report.Files[0].Issues[0].Kind)
+	assert.True(t, report.Files[0].Issues[0].Warning())
+	assert.NoError(t, report.Err())
+}
@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// RunAs is the account the commands of a test are run as. User and Group are names or
+// numeric ids. Group defaults to the primary group of User. Running as another account
+// requires the privileges to change the credentials of a process, usually root, and is
+// not supported on windows.
+type RunAs struct {
+	User  string
+	Group string
+}
+
+func (ra *RunAs) String() string {
+	if ra.Group == "" {
+		return ra.User
+	}
+	return ra.User + ":" + ra.Group
+}
+
+// ParseRunAs parses an account like user or user:group.
+func ParseRunAs(value string) (*RunAs, error) {
+	parts := strings.SplitN(value, ":", 2)
+	ra := &RunAs{User: parts[0]}
+	if len(parts) == 2 {
+		ra.Group = parts[1]
+	}
+	if ra.User == "" || (len(parts) == 2 && ra.Group == "") {
+		return nil, fmt.Errorf("%q is not a valid account, use user or user:group", value)
+	}
+	return ra, nil
+}
+
+// identity is a RunAs resolved to the ids and the home folder the launcher is started with.
+type identity struct {
+	username string
+	uid      uint32
+	gid      uint32
+	groups   []uint32
+	home     string
+}
+
+func (id *identity) String() string {
+	return fmt.Sprintf("%s(%d):%d", id.username, id.uid, id.gid)
+}
+
+// resolveIdentity looks up the account of ra. Supplementary groups are the groups the
+// user is a member of. A nil RunAs resolves to a nil identity, the current user.
+func resolveIdentity(ra *RunAs) (*identity, error) {
+	if ra == nil {
+		return nil, nil
+	}
+	if getCurrentPlatform() == windowsPlatform {
+		return nil, fmt.Errorf("running commands as another user is not supported on windows")
+	}
+	u, err := lookupUser(ra.User)
+	if err != nil {
+		return nil, err
+	}
+	id := &identity{username: u.Username, home: u.HomeDir}
+	if id.uid, err = parseID(u.Uid); err != nil {
+		return nil, fmt.Errorf("user %s: %s", ra.User, err)
+	}
+	group := u.Gid
+	if ra.Group != "" {
+		g, err := lookupGroup(ra.Group)
+		if err != nil {
+			return nil, err
+		}
+		group = g.Gid
+	}
+	if id.gid, err = parseID(group); err != nil {
+		return nil, fmt.Errorf("group %s: %s", group, err)
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the groups of user %s: %s", ra.User, err)
+	}
+	for _, groupID := range groupIDs {
+		gid, err := parseID(groupID)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %s", groupID, err)
+		}
+		id.groups = append(id.groups, gid)
+	}
+	return id, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+	if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+		if u, idErr := user.LookupId(name); idErr == nil {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown user %s", name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if err == nil {
+		return g, nil
+	}
+	if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+		if g, idErr := user.LookupGroupId(name); idErr == nil {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown group %s", name)
+}
+
+func parseID(value string) (uint32, error) {
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a numeric id", value)
+	}
+	return uint32(id), nil
+}
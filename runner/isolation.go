@@ -0,0 +1,193 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Isolation runs the launcher of the commands of a test in new linux namespaces. Root
+// changes the root filesystem seen by the commands and requires the mount namespace. A
+// zero Isolation runs the commands without isolation.
+//
+// The user namespace maps the account running the commands to root inside of it, which
+// lets an unprivileged runner create the other namespaces. Without it they can only be
+// created by root.
+type Isolation struct {
+	Namespaces []Namespace
+	Root       RootMode `json:",omitempty"`
+}
+
+// Namespace is a linux namespace the launcher can be started in.
+type Namespace string
+
+// Various Namespace's of Isolation.
+const (
+	UserNamespace    Namespace = "user"
+	MountNamespace   Namespace = "mount"
+	PIDNamespace     Namespace = "pid"
+	NetworkNamespace Namespace = "network"
+)
+
+var namespaces = []Namespace{UserNamespace, MountNamespace, PIDNamespace, NetworkNamespace}
+
+// RootMode is how the root filesystem of the host is seen by isolated commands.
+type RootMode string
+
+// Various RootMode's of Isolation. HostRoot leaves the root filesystem as it is.
+// ReadOnlyRoot binds it read-only and OverlayRoot puts a writable overlay on top of it
+// whose changes are kept until the test is done, so that every phase sees them. In both
+// modes /tmp, /var/tmp and /dev/shm are empty folders of the run and the sandbox of the
+// test stays shared with the host.
+const (
+	HostRoot     RootMode = ""
+	ReadOnlyRoot RootMode = "read-only"
+	OverlayRoot  RootMode = "overlay"
+)
+
+func (iso *Isolation) isZero() bool {
+	return iso == nil || (len(iso.Namespaces) == 0 && iso.Root == HostRoot)
+}
+
+func (iso *Isolation) has(ns Namespace) bool {
+	for _, n := range iso.Namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the isolation in the format read by ParseIsolation.
+func (iso *Isolation) String() string {
+	if iso.isZero() {
+		return "none"
+	}
+	var items []string
+	for _, ns := range iso.Namespaces {
+		items = append(items, string(ns))
+	}
+	if iso.Root != HostRoot {
+		items = append(items, string(iso.Root))
+	}
+	return strings.Join(items, ",")
+}
+
+func (iso *Isolation) validate() error {
+	if iso == nil {
+		return nil
+	}
+	for _, ns := range iso.Namespaces {
+		if !isNamespace(ns) {
+			return fmt.Errorf("unknown namespace %q", ns)
+		}
+	}
+	switch iso.Root {
+	case HostRoot:
+	case ReadOnlyRoot, OverlayRoot:
+		if !iso.has(MountNamespace) {
+			return fmt.Errorf("%s root requires the mount namespace", iso.Root)
+		}
+	default:
+		return fmt.Errorf("unknown root mode %q", iso.Root)
+	}
+	return nil
+}
+
+func isNamespace(ns Namespace) bool {
+	for _, n := range namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIsolation parses a comma separated list of namespaces and of an optional root
+// mode, like user,mount,pid,network,overlay. none is no isolation.
+func ParseIsolation(value string) (*Isolation, error) {
+	iso := &Isolation{}
+	if value == "none" {
+		return iso, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		switch mode := RootMode(item); {
+		case mode == ReadOnlyRoot || mode == OverlayRoot:
+			if iso.Root != HostRoot {
+				return nil, fmt.Errorf("%q sets more than one root mode", value)
+			}
+			iso.Root = mode
+		case isNamespace(Namespace(item)):
+			if !iso.has(Namespace(item)) {
+				iso.Namespaces = append(iso.Namespaces, Namespace(item))
+			}
+		default:
+			return nil, fmt.Errorf("%q is not a namespace or a root mode", item)
+		}
+	}
+	if err := iso.validate(); err != nil {
+		return nil, err
+	}
+	return iso, nil
+}
+
+// isolatedTempFolders are the folders for temporary files that isolated commands get in
+// place of the ones of the host, by the name of their folder in the root of a run.
+var isolatedTempFolders = map[string]string{
+	"/tmp":     "tmp",
+	"/var/tmp": "var-tmp",
+	"/dev/shm": "dev-shm",
+}
+
+// createIsolatedRoot creates the folder that keeps what the commands of a run change in an
+// isolated root, inside folder or the default folder for temporary files. It holds the
+// upper and work folders of the overlays and the folders for temporary files, and is
+// given to id like the sandbox, since the commands mount them from a user namespace.
+func createIsolatedRoot(folder string, id *identity) (string, error) {
+	dir, err := ioutil.TempDir(folder, "go-atomic-root-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create isolated root: %s", err)
+	}
+	// the helper reaches the folder through the root of the host
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	folders := []string{"upper", "work"}
+	for _, name := range isolatedTempFolders {
+		folders = append(folders, name)
+	}
+	for _, name := range folders {
+		if err = os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			break
+		}
+		if name != "upper" && name != "work" {
+			err = os.Chmod(filepath.Join(dir, name), 0777|os.ModeSticky)
+		}
+	}
+	if err == nil && id != nil {
+		err = handOverFolder(dir, id)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("unable to create isolated root: %s", err)
+	}
+	return dir, nil
+}
+
+// removeIsolatedRoot removes the folder of an isolated root. The overlays leave folders
+// without any permission in it, they are opened up first.
+func removeIsolatedRoot(dir string) error {
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && info.Mode().Perm()&0700 != 0700 {
+			_ = os.Chmod(path, info.Mode().Perm()|0700)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("unable to remove isolated root: %s", err)
+	}
+	return nil
+}
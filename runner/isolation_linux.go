@@ -0,0 +1,420 @@
+//go:build linux
+// +build linux
+
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// isolationHelper is the name the runner executes itself with to prepare the namespaces of
+// an isolated launcher, Go cannot run code between fork and exec. The helper sets up the
+// mounts and the network and then executes the launcher in its place.
+const isolationHelper = "go-atomic-isolate"
+
+// isolationSetup is what the helper prepares before it executes the launcher at Path.
+type isolationSetup struct {
+	Root    RootMode
+	Mount   bool
+	PID     bool
+	Network bool
+	// Shared are the folders that stay writable and shared with the host in an isolated
+	// root, Folder is the folder of the host that keeps the changes made to the root and
+	// Dir is the working directory of the launcher.
+	Shared []string
+	Folder string
+	Dir    string
+	Path   string
+	// Credential is applied once the mounts are done when the launcher runs as another
+	// account without a user namespace, the helper needs to be root to mount.
+	Credential *syscall.Credential
+}
+
+func init() {
+	if len(os.Args) > 2 && os.Args[0] == isolationHelper {
+		err := runIsolationHelper(os.Args[1], os.Args[2:])
+		fmt.Fprintf(os.Stderr, "%s: %s\n", isolationHelper, err)
+		os.Exit(125)
+	}
+}
+
+// isolateLauncher changes cmd so that the launcher is started in the namespaces of
+// lc.isolation, through the isolation helper when they need to be prepared.
+func isolateLauncher(cmd *exec.Cmd, lc launchConfig) error {
+	iso := lc.isolation
+	if err := iso.validate(); err != nil {
+		return err
+	}
+	attr := cmd.SysProcAttr
+	for ns, flag := range map[Namespace]uintptr{
+		UserNamespace:    syscall.CLONE_NEWUSER,
+		MountNamespace:   syscall.CLONE_NEWNS,
+		PIDNamespace:     syscall.CLONE_NEWPID,
+		NetworkNamespace: syscall.CLONE_NEWNET,
+	} {
+		if iso.has(ns) {
+			attr.Cloneflags |= flag
+		}
+	}
+	setup := isolationSetup{
+		Root:    iso.Root,
+		Mount:   iso.has(MountNamespace),
+		PID:     iso.has(PIDNamespace),
+		Network: iso.has(NetworkNamespace),
+		Shared:  lc.shared,
+		Folder:  lc.root,
+		Dir:     cmd.Dir,
+		Path:    cmd.Path,
+	}
+	if iso.has(UserNamespace) {
+		uid, gid := os.Geteuid(), os.Getegid()
+		if id := lc.identity; id != nil {
+			uid, gid = int(id.uid), int(id.gid)
+		}
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+		// root inside the namespace keeps its capabilities when it executes the launcher
+		attr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true}
+	}
+	if !setup.Mount && !setup.Network {
+		return nil
+	}
+	if setup.Root != HostRoot && setup.Folder == "" {
+		return fmt.Errorf("%s root requires a folder for its changes", setup.Root)
+	}
+	if !iso.has(UserNamespace) {
+		setup.Credential, attr.Credential = attr.Credential, nil
+	}
+	config, err := json.Marshal(setup)
+	if err != nil {
+		return err
+	}
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = append([]string{isolationHelper, string(config)}, cmd.Args...)
+	return nil
+}
+
+// runIsolationHelper prepares the namespaces the helper was started in and executes the
+// launcher. It only returns when something failed.
+func runIsolationHelper(config string, argv []string) error {
+	var setup isolationSetup
+	if err := json.Unmarshal([]byte(config), &setup); err != nil {
+		return err
+	}
+	if setup.Mount {
+		dir := setup.Dir
+		if dir == "" {
+			var err error
+			if dir, err = os.Getwd(); err != nil {
+				return err
+			}
+		}
+		// mounts made for the launcher must not propagate to the host
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("unable to make mounts private: %s", err)
+		}
+		if setup.Root != HostRoot {
+			if err := pivotIsolatedRoot(setup); err != nil {
+				return err
+			}
+		} else if setup.PID {
+			if err := syscall.Mount("proc", "/proc", "proc", 0, ""); err != nil {
+				return fmt.Errorf("unable to mount /proc: %s", err)
+			}
+		}
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+	}
+	if setup.Network {
+		if err := loopbackUp(); err != nil {
+			return err
+		}
+	}
+	if cred := setup.Credential; cred != nil {
+		groups := make([]int, len(cred.Groups))
+		for i, group := range cred.Groups {
+			groups[i] = int(group)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return err
+		}
+		if err := syscall.Setgid(int(cred.Gid)); err != nil {
+			return err
+		}
+		if err := syscall.Setuid(int(cred.Uid)); err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(setup.Path, argv, os.Environ())
+}
+
+// pivotIsolatedRoot builds the root of setup.Root and makes it the root of the launcher.
+// A tmpfs mounted on /tmp becomes the root first, so that the host root can be reached at
+// /oldroot while the new root is built at /newroot. What the commands change is kept in
+// setup.Folder, which is shared by all the launchers of a run.
+func pivotIsolatedRoot(setup isolationSetup) error {
+	const base, oldRoot, newRoot = "/tmp", "/oldroot", "/newroot"
+	if err := syscall.Mount("tmpfs", base, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("unable to mount tmpfs: %s", err)
+	}
+	for _, dir := range []string{oldRoot, newRoot} {
+		if err := os.Mkdir(base+dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := pivotRoot(base, base+oldRoot); err != nil {
+		return err
+	}
+
+	// proc is only reachable through the host root until the new root is ready
+	mountinfo := oldRoot + "/proc/self/mountinfo"
+	mounts, err := mountPoints(mountinfo, oldRoot)
+	if err != nil {
+		return err
+	}
+	folder := oldRoot + setup.Folder
+	// the tmpfs and the overlays of an overlay root are the only writable mounts
+	writable := map[string]bool{}
+	if setup.Root == OverlayRoot {
+		if err := syscall.Mount("tmpfs", newRoot, "tmpfs", 0, "mode=0755"); err != nil {
+			return fmt.Errorf("unable to mount tmpfs: %s", err)
+		}
+		// an overlay cannot keep its changes below its own lower folder, the folder that
+		// holds them is left out of the overlays like a mount point.
+		overlays, err := overlayRoot(oldRoot, newRoot, folder, append(mounts, folder))
+		if err != nil {
+			return err
+		}
+		writable[newRoot] = true
+		for _, overlay := range overlays {
+			writable[overlay] = true
+		}
+	} else if err := syscall.Mount(oldRoot, newRoot, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("unable to bind root: %s", err)
+	}
+	if mounts, err = mountPoints(mountinfo, newRoot); err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if writable[mount] {
+			continue
+		}
+		if err := remountReadOnly(mount); err != nil {
+			return err
+		}
+	}
+
+	for dir, name := range isolatedTempFolders {
+		if _, err := os.Stat(newRoot + dir); err != nil {
+			continue
+		}
+		if err := syscall.Mount(folder+"/"+name, newRoot+dir, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("unable to bind %s: %s", dir, err)
+		}
+		if err := remount(newRoot+dir, syscall.MS_NOSUID|syscall.MS_NODEV); err != nil {
+			return err
+		}
+	}
+	if setup.PID {
+		if err := syscall.Mount("proc", newRoot+"/proc", "proc", 0, ""); err != nil {
+			return fmt.Errorf("unable to mount /proc: %s", err)
+		}
+	}
+	for _, dir := range setup.Shared {
+		if err := os.MkdirAll(newRoot+dir, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Mount(oldRoot+dir, newRoot+dir, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("unable to bind %s: %s", dir, err)
+		}
+	}
+
+	if err := pivotRoot(newRoot, newRoot); err != nil {
+		return err
+	}
+	// the tmpfs that still holds the host root is now mounted on top of the new root
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unable to detach host root: %s", err)
+	}
+	return os.Chdir("/")
+}
+
+// overlayRoot fills newRoot with the top level entries of the host root at oldRoot and
+// returns the overlays it mounted. Folders that hold no mount point get a writable overlay
+// whose changes are kept in the upper folder of folder, the others are bound as they are.
+// A single overlay cannot cover the whole host root in a user namespace, the mounts
+// inherited from the host cannot be hidden below it.
+func overlayRoot(oldRoot, newRoot, folder string, mounts []string) ([]string, error) {
+	entries, err := ioutil.ReadDir(oldRoot)
+	if err != nil {
+		return nil, err
+	}
+	var overlays []string
+	for _, entry := range entries {
+		src, dst := oldRoot+"/"+entry.Name(), newRoot+"/"+entry.Name()
+		mode := entry.Mode() & (os.ModePerm | os.ModeSticky)
+		switch {
+		case entry.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.Symlink(target, dst); err != nil {
+				return nil, err
+			}
+		case entry.IsDir() && !hasMounts(mounts, src):
+			upper, work := folder+"/upper/"+entry.Name(), folder+"/work/"+entry.Name()
+			for _, dir := range []string{dst, upper, work} {
+				// the upper and work folders are left by the launchers of earlier phases
+				if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+					return nil, err
+				}
+			}
+			// the root of an overlay has the attributes of its upper folder
+			if err := os.Chmod(upper, mode); err != nil {
+				return nil, err
+			}
+			options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", src, upper, work)
+			if err := syscall.Mount("overlay", dst, "overlay", 0, options); err != nil {
+				return nil, fmt.Errorf("unable to mount overlay on /%s: %s", entry.Name(), err)
+			}
+			overlays = append(overlays, dst)
+		default:
+			if entry.IsDir() {
+				err = os.Mkdir(dst, mode)
+			} else {
+				err = ioutil.WriteFile(dst, nil, mode)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+				return nil, fmt.Errorf("unable to bind /%s: %s", entry.Name(), err)
+			}
+		}
+	}
+	return overlays, nil
+}
+
+// hasMounts returns whether path or anything below it is a mount point.
+func hasMounts(mounts []string, path string) bool {
+	for _, mount := range mounts {
+		if mount == path || strings.HasPrefix(mount, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pivotRoot makes newRoot the root and the working directory, and moves the current root
+// to putOld. When they are the same, the current root ends up mounted on top of the new
+// one.
+func pivotRoot(newRoot, putOld string) error {
+	if err := os.Chdir(newRoot); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."+strings.TrimPrefix(putOld, newRoot)); err != nil {
+		return fmt.Errorf("unable to change root: %s", err)
+	}
+	return nil
+}
+
+// mountPoints returns the mount points at or below root listed by mountinfo, parents
+// before their children.
+func mountPoints(mountinfo, root string) ([]string, error) {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mount := unescapeMountPoint(fields[4])
+		if mount == root || strings.HasPrefix(mount, root+"/") {
+			mounts = append(mounts, mount)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(mounts)
+	return mounts, nil
+}
+
+// unescapeMountPoint decodes the octal escapes mountinfo uses for spaces and the like.
+func unescapeMountPoint(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) {
+			var c byte
+			if _, err := fmt.Sscanf(value[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// remountReadOnly makes a mount read-only.
+func remountReadOnly(mount string) error {
+	return remount(mount, syscall.MS_RDONLY)
+}
+
+// remount adds flags to a mount. The flags the mount already has are kept, a user
+// namespace is not allowed to clear them.
+func remount(mount string, flags uintptr) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mount, &st); err != nil {
+		// mount points that cannot be reached, like the ones of other users, stay as is
+		return nil
+	}
+	kept := uintptr(st.Flags) & (syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV |
+		syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME)
+	if err := syscall.Mount("", mount, "", syscall.MS_BIND|syscall.MS_REMOUNT|flags|kept, ""); err != nil {
+		return fmt.Errorf("unable to remount %s: %s", mount, err)
+	}
+	return nil
+}
+
+// loopbackUp brings up the loopback interface of a new network namespace, which starts
+// down.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unable to bring up loopback: %s", err)
+	}
+	defer unix.Close(fd)
+	// struct ifreq, the flags follow the interface name
+	var ifr [40]byte
+	copy(ifr[:], "lo")
+	for _, req := range []uintptr{unix.SIOCGIFFLAGS, unix.SIOCSIFFLAGS} {
+		if req == unix.SIOCSIFFLAGS {
+			*(*uint16)(unsafe.Pointer(&ifr[unix.IFNAMSIZ])) |= unix.IFF_UP
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+			return fmt.Errorf("unable to bring up loopback: %s", errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func isolateLauncher(cmd *exec.Cmd, lc launchConfig) error {
+	return fmt.Errorf("namespaces are only supported on linux")
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"strings"
+	"time"
+)
+
+// Limits are resource limits for the commands of a test. Zero values are not limited.
+// The limits are applied to the launcher before it reads the commands, so every process it
+// starts inherits them. They are only supported on linux.
+//
+// Like all rlimits they apply to each process on its own, except for Processes which is
+// the number of processes of the user running the commands and is not enforced for root.
+type Limits struct {
+	// CPUTime is rounded up to a second.
+	CPUTime time.Duration
+	// AddressSpace is the size of the virtual memory of a process in bytes.
+	AddressSpace uint64
+	OpenFiles    uint64
+	Processes    uint64
+	// FileSize is the size in bytes of the largest file a process can write.
+	FileSize uint64
+}
+
+func (l *Limits) isZero() bool {
+	return l == nil || *l == Limits{}
+}
+
+// LimitKind identifies a resource limit.
+type LimitKind string
+
+// Various LimitKind's of Limits.
+const (
+	CPUTimeLimit      LimitKind = "cpu-time"
+	AddressSpaceLimit LimitKind = "address-space"
+	OpenFilesLimit    LimitKind = "open-files"
+	ProcessesLimit    LimitKind = "processes"
+	FileSizeLimit     LimitKind = "file-size"
+)
+
+// limitErrors are the messages commands print when a limit stops them. Limits that do
+// not send a signal make system calls fail, so this is the only trace they leave. Shells
+// also print the signals of CPU time and file size when they kill one of the commands.
+var limitErrors = map[LimitKind][]string{
+	CPUTimeLimit:      {"cpu time limit exceeded"},
+	AddressSpaceLimit: {"cannot allocate memory", "out of memory", "memoryerror", "std::bad_alloc"},
+	OpenFilesLimit:    {"too many open files"},
+	ProcessesLimit:    {"fork: resource temporarily unavailable", "fork: retry", "can't fork", "cannot fork"},
+	FileSizeLimit:     {"file size limit exceeded", "file too large"},
+}
+
+// limitSignals are the signals the kernel sends to a process that reaches a limit.
+var limitSignals = map[LimitKind][]string{
+	// the hard limit kills processes that handle SIGXCPU one second later.
+	CPUTimeLimit:  {"SIGXCPU", "SIGKILL"},
+	FileSizeLimit: {"SIGXFSZ"},
+}
+
+// limitsHit returns the limits the command most likely ran into. CPU time and file size
+// are detected by the signals they send to the launcher, or by the messages shells print
+// when they send them to a command. The other limits are detected by the errors printed
+// on stderr. Exit codes are not used since commands can exit with any code.
+func limitsHit(limits *Limits, res *CmdResult) []LimitKind {
+	if limits.isZero() || res == nil {
+		return nil
+	}
+	var hit []LimitKind
+	stderr := strings.ToLower(res.Stderr)
+	for _, kind := range []LimitKind{CPUTimeLimit, AddressSpaceLimit, OpenFilesLimit, ProcessesLimit, FileSizeLimit} {
+		if !limits.isSet(kind) {
+			continue
+		}
+		matched := false
+		for _, signal := range limitSignals[kind] {
+			// SIGKILL is also what the runner uses to stop commands that time out.
+			if res.Signal == signal && !(signal == "SIGKILL" && res.Killed) {
+				matched = true
+			}
+		}
+		for _, message := range limitErrors[kind] {
+			if strings.Contains(stderr, message) {
+				matched = true
+			}
+		}
+		if matched {
+			hit = append(hit, kind)
+		}
+	}
+	return hit
+}
+
+func (l *Limits) isSet(kind LimitKind) bool {
+	switch kind {
+	case CPUTimeLimit:
+		return l.CPUTime > 0
+	case AddressSpaceLimit:
+		return l.AddressSpace > 0
+	case OpenFilesLimit:
+		return l.OpenFiles > 0
+	case ProcessesLimit:
+		return l.Processes > 0
+	case FileSizeLimit:
+		return l.FileSize > 0
+	}
+	return false
+}
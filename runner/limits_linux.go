@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package runner
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// setLimits applies limits to a running process with prlimit.
+func setLimits(pid int, limits *Limits) error {
+	cpuSeconds := uint64((limits.CPUTime + 999999999) / 1000000000)
+	for _, limit := range []struct {
+		resource int
+		kind     LimitKind
+		value    uint64
+	}{
+		{unix.RLIMIT_CPU, CPUTimeLimit, cpuSeconds},
+		{unix.RLIMIT_AS, AddressSpaceLimit, limits.AddressSpace},
+		{unix.RLIMIT_NOFILE, OpenFilesLimit, limits.OpenFiles},
+		{unix.RLIMIT_NPROC, ProcessesLimit, limits.Processes},
+		{unix.RLIMIT_FSIZE, FileSizeLimit, limits.FileSize},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		rlim := unix.Rlimit{Cur: limit.value, Max: limit.value}
+		if limit.resource == unix.RLIMIT_CPU {
+			// the soft limit sends SIGXCPU, the hard limit one second later SIGKILL.
+			rlim.Max++
+		}
+		if err := unix.Prlimit(pid, limit.resource, &rlim, nil); err != nil {
+			return fmt.Errorf("unable to set %s limit: %s", limit.kind, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package runner
+
+import "fmt"
+
+func setLimits(pid int, limits *Limits) error {
+	return fmt.Errorf("resource limits are only supported on linux")
+}
@@ -0,0 +1,223 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+// LintSeverity is the severity of a lint finding.
+type LintSeverity string
+
+// Severities reported by Lint. Errors are problems that stop a test from being built
+// or run, warnings should be reviewed.
+const (
+	SeverityError   LintSeverity = "error"
+	SeverityWarning LintSeverity = "warning"
+)
+
+// LintRule identifies the check that produced a finding.
+type LintRule string
+
+// Various LintRule's checked by Lint. Issues found while loading technique files are
+// reported with a LoadIssueKind as their rule.
+const (
+	UndefinedPlaceholderRule LintRule = "undefined-placeholder"
+	UnusedArgumentRule       LintRule = "unused-argument"
+	MissingCleanupRule       LintRule = "missing-cleanup"
+	UnsupportedExecutorRule  LintRule = "unsupported-executor"
+	SudoElevationRule        LintRule = "sudo-with-elevation"
+)
+
+// LintFinding is a single problem found in an atomic test. TestNumber is 1 based like the
+// test numbers used on the command line and is zero for findings about the whole file.
+// Line and Column are only known for issues found while loading the file.
+type LintFinding struct {
+	Path        string
+	Line        int `json:",omitempty"`
+	Column      int `json:",omitempty"`
+	TechniqueID string
+	TestNumber  int    `json:",omitempty"`
+	TestName    string `json:",omitempty"`
+	TestGUID    string `json:",omitempty"`
+	Rule        LintRule
+	Severity    LintSeverity
+	Message     string
+}
+
+// redirectPattern matches output redirection to a file. Redirection between file
+// descriptors is not matched, redirection to null devices is filtered with nullDevices.
+var redirectPattern = regexp.MustCompile(`(?m)(^|[^0-9&<>-])>>?\s*([^\s&>|;]+)`)
+
+// fileCreatingPatterns match commands that leave files behind.
+var fileCreatingPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?im)(^|[\s;&|(])(touch|mkdir|md|cp|copy|xcopy|mv|move|tee|wget|ln|dd|tar|zip|unzip)\s`),
+	regexp.MustCompile(`(?im)(^|[\s;&|(])curl\s.*\s(-o|-O|--output)\b`),
+	regexp.MustCompile(`(?i)\b(New-Item|Out-File|Set-Content|Add-Content|Copy-Item|Move-Item|Expand-Archive|Compress-Archive|Export-Csv|Export-Clixml)\b`),
+	regexp.MustCompile(`(?i)\s-OutFile\b`),
+}
+
+var nullDevices = map[string]bool{
+	"/dev/null": true,
+	"nul":       true,
+	"$null":     true,
+	"null":      true,
+}
+
+var sudoPattern = regexp.MustCompile(`(^|[\s;&|(])sudo\s`)
+
+// createsFiles reports whether the commands look like they create files.
+func createsFiles(commands string) bool {
+	for _, match := range redirectPattern.FindAllStringSubmatch(commands, -1) {
+		if !nullDevices[strings.ToLower(match[2])] {
+			return true
+		}
+	}
+	for _, pattern := range fileCreatingPatterns {
+		if pattern.MatchString(commands) {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholders returns the names of the input arguments used in commands.
+func placeholders(commands string) []string {
+	var names []string
+	for _, pattern := range placeholderPatterns {
+		for _, match := range pattern.FindAllStringSubmatch(commands, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// Lint checks the loaded tests for problems that the schema validation done by
+// LoadTechniques does not catch. Findings are sorted by file and test.
+func (ar *Runner) Lint() []LintFinding {
+	var findings []LintFinding
+	for _, technique := range ar.techniques {
+		for index, test := range technique.AtomicTests {
+			for _, finding := range ar.lintTest(test) {
+				// tests added or replaced by an overlay come from another file than the technique.
+				finding.Path = test.Path
+				finding.TestNumber = index + 1
+				findings = append(findings, finding)
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].TestNumber < findings[j].TestNumber
+	})
+	return findings
+}
+
+// LintTest checks a single atomic test against the built-in executors. Path and
+// TestNumber are not set on the findings.
+func LintTest(test *art.Test) []LintFinding {
+	return (&Runner{}).lintTest(test)
+}
+
+// lintTest checks a single atomic test against the executors of the runner.
+func (ar *Runner) lintTest(test *art.Test) []LintFinding {
+	var findings []LintFinding
+	add := func(rule LintRule, severity LintSeverity, format string, args ...interface{}) {
+		findings = append(findings, LintFinding{
+			TechniqueID: test.TechniqueID,
+			TestName:    test.Name,
+			TestGUID:    test.AutoGeneratedGUID,
+			Rule:        rule,
+			Severity:    severity,
+			Message:     fmt.Sprintf(format, args...),
+		})
+	}
+
+	commands := []struct {
+		field string
+		text  string
+	}{
+		{"command", test.Executor.Command},
+		{"cleanup_command", test.Executor.CleanupCommand},
+		{"steps", test.Executor.Steps},
+	}
+	for index, dependency := range test.Dependencies {
+		commands = append(commands,
+			struct{ field, text string }{fmt.Sprintf("dependencies[%d].prereq_command", index), dependency.PrereqCommand},
+			struct{ field, text string }{fmt.Sprintf("dependencies[%d].get_prereq_command", index), dependency.GetPrereqCommand},
+		)
+	}
+
+	used := make(map[string]bool)
+	for _, command := range commands {
+		reported := make(map[string]bool)
+		for _, name := range placeholders(command.text) {
+			used[name] = true
+			if _, found := test.InputArguments[name]; found || reported[name] || builtinArguments[name] {
+				continue
+			}
+			reported[name] = true
+			add(UndefinedPlaceholderRule, SeverityError,
+				"%s uses placeholder %q which is not declared in input_arguments", command.field, name)
+		}
+	}
+	var declared []string
+	for name := range test.InputArguments {
+		declared = append(declared, name)
+	}
+	sort.Strings(declared)
+	for _, name := range declared {
+		if !used[name] {
+			add(UnusedArgumentRule, SeverityWarning, "input argument %q is not used by any command", name)
+		}
+	}
+
+	if test.Executor.Name != "" && !ar.supportsExecutor(test.Executor.Name) {
+		add(UnsupportedExecutorRule, SeverityError, "executor %q is not supported", test.Executor.Name)
+	}
+	if test.DependencyExecutorName != "" && !ar.supportsExecutor(test.DependencyExecutorName) {
+		add(UnsupportedExecutorRule, SeverityError, "dependency executor %q is not supported", test.DependencyExecutorName)
+	}
+
+	if test.Executor.CleanupCommand == "" && createsFiles(test.Executor.Command) {
+		add(MissingCleanupRule, SeverityWarning, "command creates files but the test has no cleanup_command")
+	}
+
+	if test.Executor.ElevationRequired {
+		for _, command := range commands {
+			if sudoPattern.MatchString(command.text) {
+				add(SudoElevationRule, SeverityWarning,
+					"%s uses sudo although the test requires elevation and is run elevated", command.field)
+			}
+		}
+	}
+	return findings
+}
+
+// LintFindings returns the issues in the report as lint findings. They are errors since the
+// files do not match the atomics schema, except for the issues that are load warnings.
+func (lr *LoadReport) LintFindings() []LintFinding {
+	var findings []LintFinding
+	for _, file := range lr.Files {
+		for _, issue := range file.Issues {
+			severity := SeverityError
+			if issue.Warning() {
+				severity = SeverityWarning
+			}
+			findings = append(findings, LintFinding{
+				Path:     file.Path,
+				Line:     issue.Line,
+				Column:   issue.Column,
+				Rule:     LintRule(issue.Kind),
+				Severity: severity,
+				Message:  issue.Message,
+			})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+func TestLintTest(t *testing.T) {
+	test := &art.Test{
+		TechniqueID: "T0000",
+		Name:        "lint",
+		InputArguments: map[string]art.Argument{
+			"used":   {Type: "string", Default: "a"},
+			"unused": {Type: "string", Default: "b"},
+		},
+		DependencyExecutorName: "fish",
+		Dependencies: []art.Dependency{
+			{PrereqCommand: "test -f #{tool}", GetPrereqCommand: "sudo apt-get install #{tool}"},
+		},
+		Executor: art.Executor{
+			Name:              "zsh-custom",
+			ElevationRequired: true,
+			Command:           "echo #{used} > /tmp/out.txt\necho ${missing} ${missing} #{go_atomic_workdir}",
+		},
+	}
+	findings := LintTest(test)
+	var got []string
+	for _, finding := range findings {
+		got = append(got, string(finding.Severity)+" "+string(finding.Rule)+": "+finding.Message)
+		assert.Equal(t, "T0000", finding.TechniqueID)
+	}
+	assert.Equal(t, []string{
+		`error undefined-placeholder: command uses placeholder "missing" which is not declared in input_arguments`,
+		`error undefined-placeholder: dependencies[0].prereq_command uses placeholder "tool" which is not declared in input_arguments`,
+		`error undefined-placeholder: dependencies[0].get_prereq_command uses placeholder "tool" which is not declared in input_arguments`,
+		`warning unused-argument: input argument "unused" is not used by any command`,
+		`error unsupported-executor: executor "zsh-custom" is not supported`,
+		`error unsupported-executor: dependency executor "fish" is not supported`,
+		`warning missing-cleanup: command creates files but the test has no cleanup_command`,
+		`warning sudo-with-elevation: dependencies[0].get_prereq_command uses sudo although the test requires elevation and is run elevated`,
+	}, got)
+}
+
+func TestCreatesFiles(t *testing.T) {
+	for command, want := range map[string]bool{
+		"whoami":                 false,
+		"ls 2>&1 > /dev/null":    false,
+		"dir > nul":              false,
+		"Get-Process | Out-Null": false,
+		"echo foo >> ~/.bashrc":  true,
+		"echo foo>out.txt":       true,
+		"touch /tmp/x":           true,
+		"curl -s https://example.com -o payload.sh":        true,
+		"Invoke-WebRequest $url -OutFile $env:TEMP\\a.exe": true,
+		"New-Item -Path HKCU:\\Software\\x":                true,
+	} {
+		assert.Equal(t, want, createsFiles(command), command)
+	}
+}
+
+func TestLint(t *testing.T) {
+	ar, err := newRunner(testFolder)
+	require.NoError(t, err)
+	findings := ar.Lint()
+	require.NotEmpty(t, findings)
+	for _, finding := range findings {
+		assert.NotEmpty(t, finding.Path)
+		assert.NotZero(t, finding.TestNumber)
+	}
+	// T9999 Test1 uses command_prompt which is supported and all its arguments are used
+	for _, finding := range findings {
+		if finding.TechniqueID == "T9999" && finding.TestNumber == 1 {
+			t.Errorf("unexpected finding %+v", finding)
+		}
+	}
+}
+
+func TestLoadReportLintFindings(t *testing.T) {
+	ar := &Runner{AtomicsFolder: "testdata/invalid"}
+	report, err := ar.LoadTechniques()
+	require.NoError(t, err)
+	findings := report.LintFindings()
+	require.NotEmpty(t, findings)
+	for _, finding := range findings {
+		assert.Equal(t, SeverityError, finding.Severity)
+	}
+	assert.Equal(t, LintRule(ParseIssue), findings[0].Rule)
+	assert.Equal(t, 5, findings[0].Line)
+}
+
+func TestLint_Overlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlFile := filepath.Join(dir, "T9998", "T9998.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(yamlFile), 0755))
+	require.NoError(t, ioutil.WriteFile(yamlFile, []byte(`---
+attack_technique: T9998
+display_name: Private
+
+atomic_tests:
+  - name: Private Test
+    supported_platforms:
+      - linux
+    input_arguments:
+      unused:
+        type: string
+        default: x
+    executor:
+      name: sh
+      command: echo private
+`), 0644))
+
+	ar := &Runner{AtomicsFolder: testFolder, OverlayFolders: []string{dir}}
+	defer ar.Close()
+	report, err := ar.LoadTechniques()
+	require.NoError(t, err)
+
+	// a missing guid is a warning
+	var guidFindings []LintFinding
+	for _, finding := range report.LintFindings() {
+		if finding.Rule == LintRule(MissingGUIDIssue) && finding.Path == yamlFile {
+			guidFindings = append(guidFindings, finding)
+		}
+	}
+	require.Equal(t, 1, len(guidFindings))
+	assert.Equal(t, SeverityWarning, guidFindings[0].Severity)
+
+	// findings of tests added by an overlay point to the overlay file
+	var overlayFindings []LintFinding
+	for _, finding := range ar.Lint() {
+		if finding.TestName == "Private Test" {
+			overlayFindings = append(overlayFindings, finding)
+		}
+	}
+	require.Equal(t, 1, len(overlayFindings))
+	assert.Equal(t, UnusedArgumentRule, overlayFindings[0].Rule)
+	assert.Equal(t, yamlFile, overlayFindings[0].Path)
+}
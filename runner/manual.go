@@ -0,0 +1,233 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const manualExecutor = "manual"
+
+// StepOutcome is the result of a manual test step as reported by the operator.
+type StepOutcome string
+
+// Various StepOutcome's an operator can report for a step.
+const (
+	StepDone    StepOutcome = "done"
+	StepSkipped StepOutcome = "skipped"
+	StepFailed  StepOutcome = "failed"
+)
+
+// parseStepOutcome accepts the outcome names and their first letter.
+func parseStepOutcome(value string) (StepOutcome, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "d", "done":
+		return StepDone, nil
+	case "s", "skip", "skipped":
+		return StepSkipped, nil
+	case "f", "fail", "failed":
+		return StepFailed, nil
+	}
+	return "", fmt.Errorf("%q is not a valid step outcome, use done, skipped or failed", value)
+}
+
+// StepResult is what the operator reported for a step of a manual test.
+type StepResult struct {
+	Outcome StepOutcome
+	Note    string
+}
+
+// UnmarshalYAML accepts either an outcome like done or a mapping with outcome and note.
+func (sr *StepResult) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Outcome string
+		Note    string
+	}
+	if value.Kind == yaml.ScalarNode {
+		raw.Outcome = value.Value
+	} else if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	outcome, err := parseStepOutcome(raw.Outcome)
+	if err != nil {
+		return fmt.Errorf("line %d: %s", value.Line, err)
+	}
+	sr.Outcome, sr.Note = outcome, raw.Note
+	return nil
+}
+
+// StepConfirmer is asked for the outcome of every step when a manual test is run. Number
+// is 1 based and step is the text of the step with the input arguments substituted.
+type StepConfirmer interface {
+	ConfirmStep(ctx context.Context, bt *BuiltTest, number int, step string) (StepResult, error)
+}
+
+var stepNumberPattern = regexp.MustCompile(`^\s*(\d+)[.)]\s+`)
+
+// parseSteps splits the steps of a manual test into a checklist. Steps are usually
+// numbered, lines that do not start with a number belong to the previous step. Steps
+// that are not numbered at all are split by line.
+func parseSteps(steps string) []string {
+	lines := strings.Split(strings.TrimSpace(steps), "\n")
+	numbered := false
+	for _, line := range lines {
+		if stepNumberPattern.MatchString(line) {
+			numbered = true
+			break
+		}
+	}
+	var checklist []string
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !numbered {
+			checklist = append(checklist, strings.TrimSpace(line))
+			continue
+		}
+		if loc := stepNumberPattern.FindStringIndex(line); loc != nil {
+			checklist = append(checklist, line[loc[1]:])
+			continue
+		}
+		if len(checklist) == 0 {
+			// text before the first numbered step
+			checklist = append(checklist, strings.TrimSpace(line))
+			continue
+		}
+		checklist[len(checklist)-1] += "\n" + strings.TrimSpace(line)
+	}
+	return checklist
+}
+
+// Runbook renders the steps of a built manual test as a numbered checklist.
+func (bt *BuiltTest) Runbook() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s\n", bt.TechniqueID, bt.TestName)
+	for index, step := range bt.Steps {
+		lines := strings.Split(step, "\n")
+		fmt.Fprintf(&sb, "%3d. [ ] %s\n", index+1, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(&sb, "         %s\n", line)
+		}
+	}
+	return sb.String()
+}
+
+// runManualSteps asks confirmer for the outcome of every step. All the steps are
+// confirmed even when one of them fails, the failed steps are returned as an error.
+func runManualSteps(ctx context.Context, bt *BuiltTest, confirmer StepConfirmer) ([]ManualStepInfo, error) {
+	if len(bt.Steps) == 0 {
+		return nil, fmt.Errorf("no steps provided")
+	}
+	var infos []ManualStepInfo
+	var failed []string
+	for index, step := range bt.Steps {
+		if err := ctx.Err(); err != nil {
+			return infos, err
+		}
+		info := ManualStepInfo{Number: index + 1, Step: step, StartTime: time.Now()}
+		result, err := confirmer.ConfirmStep(ctx, bt, index+1, step)
+		info.EndTime = time.Now()
+		if err != nil {
+			return infos, fmt.Errorf("step %d: %s", index+1, err)
+		}
+		info.Outcome, info.Note = result.Outcome, result.Note
+		infos = append(infos, info)
+		if result.Outcome == StepFailed {
+			failed = append(failed, fmt.Sprintf("step %d failed: %s", index+1, result.Note))
+		}
+	}
+	if len(failed) > 0 {
+		return infos, fmt.Errorf("%s", strings.Join(failed, ", "))
+	}
+	return infos, nil
+}
+
+// InteractiveConfirmer shows the runbook on Out and reads the outcome of every step from
+// In. The operator answers with done, skipped or failed, or their first letter, followed
+// by an optional note.
+type InteractiveConfirmer struct {
+	In  io.Reader
+	Out io.Writer
+
+	reader *bufio.Reader
+}
+
+// ConfirmStep implements StepConfirmer.
+func (ic *InteractiveConfirmer) ConfirmStep(ctx context.Context, bt *BuiltTest, number int, step string) (StepResult, error) {
+	if ic.reader == nil {
+		ic.reader = bufio.NewReader(ic.In)
+	}
+	if number == 1 {
+		fmt.Fprintf(ic.Out, "\n%s\n", bt.Runbook())
+	}
+	for {
+		fmt.Fprintf(ic.Out, "step %d/%d: %s\n[d]one, [s]kipped or [f]ailed, followed by an optional note: ",
+			number, len(bt.Steps), step)
+		line, err := ic.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || strings.TrimSpace(line) == "") {
+			return StepResult{}, fmt.Errorf("unable to read step outcome: %s", err)
+		}
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		outcome, parseErr := parseStepOutcome(fields[0])
+		if parseErr != nil {
+			fmt.Fprintf(ic.Out, "%s\n", parseErr)
+			continue
+		}
+		result := StepResult{Outcome: outcome}
+		if len(fields) == 2 {
+			result.Note = strings.TrimSpace(fields[1])
+		}
+		return result, nil
+	}
+}
+
+// StepResults holds the outcome of manual test steps recorded ahead of time. Tests are
+// keyed by their guid, or by their name for tests without one, and steps by their number.
+type StepResults map[string]map[int]StepResult
+
+// LoadStepResults reads step results from a yaml file like
+//
+//	5f6fcd68-fe35-46e1-a0e6-3e5c6b3f2e0b:
+//	  1: done
+//	  2:
+//	    outcome: failed
+//	    note: blocked by the proxy
+func LoadStepResults(file string) (StepResults, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var results StepResults
+	if err := yaml.Unmarshal(content, &results); err != nil {
+		return nil, fmt.Errorf("unable to parse step results %s: %s", file, err)
+	}
+	return results, nil
+}
+
+// ConfirmStep implements StepConfirmer.
+func (sr StepResults) ConfirmStep(ctx context.Context, bt *BuiltTest, number int, step string) (StepResult, error) {
+	steps, found := sr[bt.TestName]
+	for key, value := range sr {
+		if bt.TestGUID != "" && strings.EqualFold(key, bt.TestGUID) {
+			steps, found = value, true
+			break
+		}
+	}
+	if !found {
+		return StepResult{}, fmt.Errorf("no results recorded for test %q", bt.TestName)
+	}
+	result, found := steps[number]
+	if !found {
+		return StepResult{}, fmt.Errorf("no result recorded for step %d of test %q", number, bt.TestName)
+	}
+	return result, nil
+}
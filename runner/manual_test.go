@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+func TestParseSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		want  []string
+	}{
+		{"numbered", "1. Open the browser\n2. Browse to\n   the page\n\n3) Close it\n", []string{
+			"Open the browser", "Browse to\nthe page", "Close it"}},
+		{"preamble", "As an admin:\n1. Log in", []string{"As an admin:", "Log in"}},
+		{"plain", "do this\ndo that", []string{"do this", "do that"}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseSteps(tt.steps))
+		})
+	}
+}
+
+func manualTest() *art.Test {
+	return &art.Test{
+		TechniqueID:       "T0000",
+		Name:              "manual",
+		AutoGeneratedGUID: "0a9c3a3c-5d39-4f7e-9a07-1c7d0a3e6b11",
+		SupportedPlatforms: []string{
+			getCurrentPlatform(),
+		},
+		InputArguments: map[string]art.Argument{
+			"site": {Type: "url", Default: "https://example.com"},
+		},
+		Executor: art.Executor{
+			Name:  "manual",
+			Steps: "1. Browse to #{site}\n2. Download the file\n3. Open it",
+		},
+	}
+}
+
+func TestBuildTest_Manual(t *testing.T) {
+	ar := &Runner{AtomicsFolder: testFolder}
+	bt, err := ar.BuildTest(manualTest(), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Browse to https://example.com", "Download the file", "Open it"}, bt.Steps)
+	assert.Equal(t, "T0000: manual\n"+
+		"  1. [ ] Browse to https://example.com\n"+
+		"  2. [ ] Download the file\n"+
+		"  3. [ ] Open it\n", bt.Runbook())
+}
+
+func TestRunTest_Manual(t *testing.T) {
+	ar := &Runner{AtomicsFolder: testFolder}
+	rc := &TestRunConfig{EnableAll: true}
+	_, err := ar.RunTest(context.Background(), manualTest(), nil, rc)
+	require.Error(t, err)
+
+	var out bytes.Buffer
+	rc.StepConfirmer = &InteractiveConfirmer{
+		In:  strings.NewReader("d\nmaybe\ns not in scope\nfailed blocked by proxy"),
+		Out: &out,
+	}
+	tri, err := ar.RunTest(context.Background(), manualTest(), nil, rc)
+	require.Error(t, err)
+	assert.IsType(t, RunTestError{}, err)
+	assert.Contains(t, err.Error(), "step 3 failed: blocked by proxy")
+	require.Equal(t, 3, len(tri.ManualSteps))
+	assert.Equal(t, StepDone, tri.ManualSteps[0].Outcome)
+	assert.Equal(t, StepSkipped, tri.ManualSteps[1].Outcome)
+	assert.Equal(t, "not in scope", tri.ManualSteps[1].Note)
+	assert.Equal(t, StepFailed, tri.ManualSteps[2].Outcome)
+	assert.Equal(t, "Browse to https://example.com", tri.ManualSteps[0].Step)
+	assert.Contains(t, out.String(), "  1. [ ] Browse to https://example.com")
+	assert.Contains(t, out.String(), `"maybe" is not a valid step outcome`)
+
+	// running out of input fails the test instead of blocking
+	rc.StepConfirmer = &InteractiveConfirmer{In: strings.NewReader("d\n"), Out: &out}
+	tri, err = ar.RunTest(context.Background(), manualTest(), nil, rc)
+	require.Error(t, err)
+	assert.Equal(t, 1, len(tri.ManualSteps))
+}
+
+func TestStepResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "results.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+0A9C3A3C-5D39-4F7E-9A07-1C7D0A3E6B11:
+  1: done
+  2: skip
+  3:
+    outcome: done
+    note: opened with notepad
+`), 0644))
+	results, err := LoadStepResults(file)
+	require.NoError(t, err)
+
+	ar := &Runner{AtomicsFolder: testFolder}
+	tri, err := ar.RunTest(context.Background(), manualTest(), nil, &TestRunConfig{EnableAll: true, StepConfirmer: results})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(tri.ManualSteps))
+	assert.Equal(t, StepSkipped, tri.ManualSteps[1].Outcome)
+	assert.Equal(t, "opened with notepad", tri.ManualSteps[2].Note)
+
+	other := manualTest()
+	other.AutoGeneratedGUID = ""
+	_, err = ar.RunTest(context.Background(), other, nil, &TestRunConfig{EnableAll: true, StepConfirmer: results})
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("manual:\n  1: later\n"), 0644))
+	_, err = LoadStepResults(file)
+	assert.Error(t, err)
+}
+
+func TestRunTest_ManualUnsupportedPlatform(t *testing.T) {
+	test := manualTest()
+	test.SupportedPlatforms = []string{"office-365"}
+	ar := &Runner{AtomicsFolder: testFolder}
+	_, err := ar.RunTest(context.Background(), test, nil, &TestRunConfig{EnableAll: true, StepConfirmer: StepResults{}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), getCurrentPlatform())
+}
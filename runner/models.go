@@ -1,6 +1,9 @@
 package runner
 
-import "time"
+import (
+	"syscall"
+	"time"
+)
 
 // BuiltTest represents an atomic test after its commands have been substituted
 // with the supplied input arguments. A built test is what will be run by the runner.
@@ -15,6 +18,8 @@ type BuiltTest struct {
 	DependencyInfo     *DependencyInfo
 	AtomicTestCommands string
 	CleanupCommands    string
+	// Steps is the checklist of a manual test with the input arguments substituted.
+	Steps []string `json:",omitempty"`
 }
 
 // DependencyInfo represents all the built dependencies needed for the test to run
@@ -23,8 +28,6 @@ type DependencyInfo struct {
 	Executor     string
 	Launcher     []string
 	Dependencies []BuiltDependency
-
-	supportedExecutor bool
 }
 
 // BuiltDependency represents one built dependency for a test case.
@@ -45,6 +48,35 @@ type TestRunInfo struct {
 	DependencyInfo *DependencyRunInfo
 	AtomicTest     []CmdRunInfo
 	Cleanup        []CmdRunInfo
+	ManualSteps    []ManualStepInfo `json:",omitempty"`
+	// User and CleanupUser are the accounts the commands were run as, like nobody(65534):65534.
+	// They are empty when the commands were run as the current user.
+	User        string `json:",omitempty"`
+	CleanupUser string `json:",omitempty"`
+	// Environment holds the working directory and the variables the runner set for the
+	// commands of every phase. Phases that inherit the environment of the runner are left out.
+	Environment map[RunPhase]*PhaseEnvironment `json:",omitempty"`
+	// Workdir is the sandbox of the run and WorkdirFiles what the commands left in it.
+	Workdir      string         `json:",omitempty"`
+	WorkdirFiles []WorkdirEntry `json:",omitempty"`
+	// RemainingProcesses are the processes still running in the cgroup of the run once
+	// cleanup was done, they were killed afterwards. Cgroup is the usage of the cgroup.
+	RemainingProcesses []ProcessInfo `json:",omitempty"`
+	Cgroup             *CgroupStats  `json:",omitempty"`
+	// Isolation are the namespaces and the root the commands were run with.
+	Isolation *Isolation `json:",omitempty"`
+	// Target is the host the commands were run on, it is empty for the host of the runner.
+	Target string `json:",omitempty"`
+}
+
+// ManualStepInfo represents one step of a manual test and the outcome reported for it.
+type ManualStepInfo struct {
+	Number    int
+	Step      string
+	Outcome   StepOutcome
+	Note      string
+	StartTime time.Time
+	EndTime   time.Time
 }
 
 // CmdRunInfo represents one set of commands to run and their results.
@@ -65,21 +97,46 @@ type DependencyRunResults struct {
 	GetPreReq []CmdRunInfo
 }
 
-// CmdResult represents the results of a command execution.
+// CmdResult represents the results of a command execution. When a stream is larger
+// than the limit set in TestRunConfig, only the beginning and the end of it are kept,
+// the Truncated flag is set and DroppedBytes is the size of the part left out. The
+// complete stream is in File when an output spill folder is configured.
+//
+// Killed is set when the command was stopped because it timed out or was canceled, in
+// which case ExitCode is -1. Signal is the name of the signal that ended the launcher,
+// like SIGTERM, and is empty when it exited by itself. LimitsHit are the resource limits
+// the command most likely ran into.
 type CmdResult struct {
 	PID       int
 	Stdout    string
 	Stderr    string
 	ExitCode  int
+	Signal    string `json:",omitempty"`
+	Killed    bool
+	LimitsHit []LimitKind `json:",omitempty"`
 	StartTime time.Time
 	EndTime   time.Time
+
+	StdoutTruncated    bool   `json:",omitempty"`
+	StdoutDroppedBytes int64  `json:",omitempty"`
+	StdoutFile         string `json:",omitempty"`
+	StderrTruncated    bool   `json:",omitempty"`
+	StderrDroppedBytes int64  `json:",omitempty"`
+	StderrFile         string `json:",omitempty"`
 }
 
 // FilterConfig represents options to filter techniques.
 type FilterConfig struct {
-	Platform      string
+	Platform string
+	// Techniques selects techniques by id. A technique includes all of its sub-techniques.
+	// Glob patterns like T1003.*, inclusive ranges like T1055.001-T1055.004 and exclusions
+	// like !T1485 are supported. All techniques are selected when only exclusions are given.
 	Techniques    []string
 	IncludeManual bool
+	// Tactics selects techniques that belong to any of the listed ATT&CK tactics. Both the
+	// tactic name and its kill chain phase name are accepted, for example "Credential Access"
+	// or credential-access. It requires the runner to be loaded with an ATT&CK bundle.
+	Tactics []string
 }
 
 // TestRunConfig represents the options to control the execution of a test.
@@ -95,4 +152,105 @@ type TestRunConfig struct {
 	EnableDependency  bool
 
 	SplitCmdsByNewline bool
+
+	// OutputHandler receives the output of the commands line by line while they run. It
+	// is called from the goroutines reading the output but never concurrently for the
+	// same test.
+	OutputHandler func(OutputEvent)
+
+	// MaxStdoutBytes and MaxStderrBytes limit how much output of each command is kept in
+	// CmdResult. When a stream is larger, the first and the last half of the limit are
+	// kept. Zero keeps all the output.
+	MaxStdoutBytes int64
+	MaxStderrBytes int64
+	// Timeout is the budget for the dependencies and the test of a run, cleanup is not
+	// included. PreReqTimeout and GetPreReqTimeout apply to the commands of every
+	// dependency, TestTimeout to the test commands and CleanupTimeout to the cleanup
+	// commands. Zero means no timeout, except for CleanupTimeout.
+	//
+	// Cleanup always gets its full CleanupTimeout, even when the test timed out or the
+	// context passed to RunTest is done, so it always has a deadline. When CleanupTimeout
+	// is zero, Timeout is used and DefaultCleanupTimeout when that is zero too.
+	Timeout          time.Duration
+	PreReqTimeout    time.Duration
+	GetPreReqTimeout time.Duration
+	TestTimeout      time.Duration
+	CleanupTimeout   time.Duration
+
+	// TerminationSignal is sent to the processes of a command when it times out or is
+	// canceled, SIGTERM is used when it is not set. Processes still running after
+	// TerminationGracePeriod are killed with SIGKILL, right after the signal when it is zero.
+	// Windows has no signals and always kills the processes right away.
+	TerminationSignal      syscall.Signal
+	TerminationGracePeriod time.Duration
+
+	// RunAs is the account the dependencies and the test are run as, the current user runs
+	// them when it is nil. CleanupRunAs is the account cleanup is run as, it defaults to
+	// RunAs. Only unix platforms support running commands as another account.
+	RunAs        *RunAs
+	CleanupRunAs *RunAs
+
+	// Environment is the environment of all the commands of the test. PhaseEnvironments
+	// are applied on top of it for the commands of a phase.
+	Environment       Environment
+	PhaseEnvironments map[RunPhase]*Environment
+
+	// Sandbox creates an empty folder for every run of a test that is used as the working
+	// directory and as TMPDIR by all the commands, and by #{go_atomic_workdir}. It is created
+	// in SandboxFolder, or in the default folder for temporary files, and removed once the
+	// test is done unless KeepSandbox is set.
+	Sandbox       bool
+	SandboxFolder string
+	KeepSandbox   bool
+
+	// Limits are the resource limits of all the commands of the test.
+	Limits Limits
+
+	// Cgroup places all the processes of every run of a test in a new cgroup v2, created in
+	// CgroupParent or in the cgroup of the runner. Processes that leave the process group
+	// of their launcher are killed with it when a command times out or is canceled, and
+	// whatever is left once the test is done is recorded and killed. Only linux supports it.
+	Cgroup       bool
+	CgroupParent string
+
+	// Isolation runs the commands of every test in new linux namespaces. TestIsolation
+	// overrides it for the tests whose guid or technique id is a key, a zero Isolation
+	// runs them without isolation.
+	Isolation     *Isolation
+	TestIsolation map[string]*Isolation
+
+	// Target is the host the commands are run on, the host of the runner when it is nil.
+	// Sandboxes, accounts, environments, limits, cgroups and isolation are only supported on
+	// the host of the runner.
+	Target Target
+
+	// OutputSpillFolder is where the complete output of streams that were truncated is
+	// written. Nothing is written when it is empty.
+	OutputSpillFolder string
+
+	// StepConfirmer is used to run tests with the manual executor. Manual tests cannot be
+	// run when it is not set.
+	StepConfirmer StepConfirmer
+}
+
+// LoadReport describes the problems found while loading technique files.
+type LoadReport struct {
+	Files []FileReport
+}
+
+// FileReport lists the issues found in a single technique file. Skipped is set
+// when the file could not be loaded at all.
+type FileReport struct {
+	Path    string
+	Skipped bool
+	Issues  []LoadIssue
+}
+
+// LoadIssue represents a single schema or syntax problem in a technique file.
+// Line and Column are 1 based and zero when the position is not known.
+type LoadIssue struct {
+	Kind    LoadIssueKind
+	Line    int
+	Column  int
+	Message string
 }
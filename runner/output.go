@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// RunPhase identifies which commands of a test are running.
+type RunPhase string
+
+// Various RunPhase's of a test run.
+const (
+	PreReqPhase    RunPhase = "prereq"
+	GetPreReqPhase RunPhase = "getprereq"
+	TestPhase      RunPhase = "test"
+	CleanupPhase   RunPhase = "cleanup"
+)
+
+// ParseRunPhase parses the name of a phase like cleanup.
+func ParseRunPhase(name string) (RunPhase, error) {
+	for _, phase := range []RunPhase{PreReqPhase, GetPreReqPhase, TestPhase, CleanupPhase} {
+		if name == string(phase) {
+			return phase, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a phase, use prereq, getprereq, test or cleanup", name)
+}
+
+// OutputStream is the stream a command wrote output to.
+type OutputStream string
+
+// The streams of a command.
+const (
+	Stdout OutputStream = "stdout"
+	Stderr OutputStream = "stderr"
+)
+
+// OutputEvent is output produced by a command while it runs. Data holds one line of
+// output including its newline. The last line of a stream does not end with a newline
+// when the command did not write one, and lines longer than maxLineLength are split.
+type OutputEvent struct {
+	TechniqueID string
+	TestName    string
+	TestGUID    string
+	Phase       RunPhase
+	Command     string
+	PID         int
+	Stream      OutputStream
+	Data        []byte
+}
+
+// outputSink forwards the output of the commands of a test to an output handler. The
+// handler is never called concurrently by the same sink.
+type outputSink struct {
+	handler func(OutputEvent)
+	// event is copied into every event sent to the handler.
+	event OutputEvent
+	mu    sync.Mutex
+}
+
+func newOutputSink(handler func(OutputEvent), bt *BuiltTest) *outputSink {
+	if handler == nil {
+		return nil
+	}
+	return &outputSink{
+		handler: handler,
+		event: OutputEvent{
+			TechniqueID: bt.TechniqueID,
+			TestName:    bt.TestName,
+			TestGUID:    bt.TestGUID,
+		},
+	}
+}
+
+// writer returns a writer that sends the output of a command to the handler line by line.
+// The writer has to be closed to send the last line when it has no newline.
+func (sink *outputSink) writer(phase RunPhase, command string, pid int, stream OutputStream) *lineWriter {
+	if sink == nil {
+		return nil
+	}
+	event := sink.event
+	event.Phase, event.Command, event.PID, event.Stream = phase, command, pid, stream
+	return &lineWriter{sink: sink, event: event}
+}
+
+func (sink *outputSink) send(event OutputEvent, data []byte) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	event.Data = append([]byte(nil), data...)
+	sink.handler(event)
+}
+
+// maxLineLength is the most output held back while waiting for the end of a line.
+const maxLineLength = 64 << 10
+
+// lineWriter splits output into lines. A nil lineWriter discards everything.
+type lineWriter struct {
+	sink  *outputSink
+	event OutputEvent
+	buf   []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	if lw == nil {
+		return len(p), nil
+	}
+	lw.buf = append(lw.buf, p...)
+	for {
+		index := bytes.IndexByte(lw.buf, '\n')
+		if index < 0 {
+			break
+		}
+		lw.sink.send(lw.event, lw.buf[:index+1])
+		lw.buf = lw.buf[index+1:]
+	}
+	if len(lw.buf) >= maxLineLength {
+		lw.sink.send(lw.event, lw.buf)
+		lw.buf = nil
+	}
+	return len(p), nil
+}
+
+// Close sends the output that is left.
+func (lw *lineWriter) Close() error {
+	if lw == nil || len(lw.buf) == 0 {
+		return nil
+	}
+	lw.sink.send(lw.event, lw.buf)
+	lw.buf = nil
+	return nil
+}
@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineWriter(t *testing.T) {
+	var events []OutputEvent
+	sink := newOutputSink(func(event OutputEvent) {
+		events = append(events, event)
+	}, &BuiltTest{TechniqueID: "T0000", TestName: "test"})
+	lw := sink.writer(TestPhase, "echo", 10, Stderr)
+	for _, chunk := range []string{"he", "llo\nwor", "ld\n\npartial"} {
+		n, err := lw.Write([]byte(chunk))
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.NoError(t, lw.Close())
+	var lines []string
+	for _, event := range events {
+		lines = append(lines, string(event.Data))
+		assert.Equal(t, "T0000", event.TechniqueID)
+		assert.Equal(t, TestPhase, event.Phase)
+		assert.Equal(t, Stderr, event.Stream)
+		assert.Equal(t, 10, event.PID)
+	}
+	assert.Equal(t, []string{"hello\n", "world\n", "\n", "partial"}, lines)
+
+	// long lines are not held back
+	events = nil
+	lw = sink.writer(TestPhase, "echo", 10, Stdout)
+	_, _ = lw.Write([]byte(strings.Repeat("a", maxLineLength+1)))
+	assert.Equal(t, 1, len(events))
+
+	// writers of a nil sink discard the output
+	var none *outputSink
+	lw = none.writer(TestPhase, "echo", 10, Stdout)
+	_, err := lw.Write([]byte("hello\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, lw.Close())
+}
@@ -1,28 +1,196 @@
 package runner
 
 import (
-	"io/ioutil"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 
 	"github.com/ejohn/go-atomic/art"
 )
 
+// validPlatforms lists the values atomic red team uses in supported_platforms.
+var validPlatforms = map[string]bool{
+	windowsPlatform:    true,
+	macos:              true,
+	linux:              true,
+	"office-365":       true,
+	"azure-ad":         true,
+	"google-workspace": true,
+	"saas":             true,
+	"iaas":             true,
+	"iaas:aws":         true,
+	"iaas:azure":       true,
+	"iaas:gcp":         true,
+	"containers":       true,
+	"esxi":             true,
+}
+
 // parse is factored out to make yaml unmarshalling easily testable.
 func parse(content []byte) (art.Technique, error) {
-	var technique art.Technique
-	err := yaml.Unmarshal(content, &technique)
-	if err != nil {
-		return art.Technique{}, err
+	technique, issues := parseAndValidate(content)
+	for _, issue := range issues {
+		if issue.Kind == ParseIssue {
+			return art.Technique{}, issue
+		}
 	}
 	return technique, nil
 }
 
-// parseYAMLFile parses a yaml technique file and unmarshall's the contents into a struct.
-func parseYAMLFile(file string) (art.Technique, error) {
-	content, err := ioutil.ReadFile(file)
-	if err != nil {
-		return art.Technique{}, err
+// parseAndValidate unmarshalls a technique and checks it against the atomic red team
+// schema. Issues of kind ParseIssue mean the returned technique is not usable.
+func parseAndValidate(content []byte) (art.Technique, []LoadIssue) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return art.Technique{}, yamlErrorIssues(err)
+	}
+	if len(root.Content) == 0 {
+		return art.Technique{}, []LoadIssue{{Kind: ParseIssue, Message: "file is empty"}}
+	}
+	var technique art.Technique
+	if err := root.Decode(&technique); err != nil {
+		return art.Technique{}, yamlErrorIssues(err)
+	}
+
+	doc := root.Content[0]
+	var issues []LoadIssue
+	issues = append(issues, unknownKeys(doc, reflect.TypeOf(technique))...)
+	if technique.ID == "" {
+		issues = append(issues, newIssue(MissingTechniqueIDIssue, doc, "attack_technique is not set"))
+	}
+
+	tests := mappingValue(doc, "atomic_tests")
+	if tests == nil || tests.Kind != yaml.SequenceNode {
+		return technique, issues
+	}
+	for index, test := range tests.Content {
+		name := strconv.Itoa(index + 1)
+		if nameNode := mappingValue(test, "name"); nameNode != nil {
+			name = strconv.Quote(nameNode.Value)
+		}
+		if mappingValue(test, "auto_generated_guid") == nil {
+			issues = append(issues, newIssue(MissingGUIDIssue, test,
+				fmt.Sprintf("test %s has no auto_generated_guid", name)))
+		}
+		if mappingValue(test, "executor") == nil {
+			issues = append(issues, newIssue(MissingExecutorIssue, test,
+				fmt.Sprintf("test %s has no executor", name)))
+		}
+		platforms := mappingValue(test, "supported_platforms")
+		if platforms == nil {
+			continue
+		}
+		for _, platform := range platforms.Content {
+			if !validPlatforms[platform.Value] {
+				issues = append(issues, newIssue(InvalidPlatformIssue, platform,
+					fmt.Sprintf("test %s has invalid platform %q", name, platform.Value)))
+			}
+		}
+	}
+	return technique, issues
+}
+
+func newIssue(kind LoadIssueKind, node *yaml.Node, message string) LoadIssue {
+	return LoadIssue{
+		Kind:    kind,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: message,
+	}
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+): `)
+
+// yamlErrorIssues converts errors returned by the yaml decoder to issues. The decoder only
+// reports line numbers in its messages, so the column is left unset.
+func yamlErrorIssues(err error) []LoadIssue {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+	var issues []LoadIssue
+	for _, message := range messages {
+		issue := LoadIssue{Kind: ParseIssue}
+		if loc := yamlLineRe.FindStringSubmatchIndex(message); loc != nil {
+			issue.Line, _ = strconv.Atoi(message[loc[2]:loc[3]])
+			message = message[loc[1]:]
+		}
+		issue.Message = strings.TrimPrefix(message, "yaml: ")
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// mappingValue returns the value node for key in a mapping node or nil if it is not present.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// unknownKeys walks node alongside the art struct type t and reports keys that
+// do not map to any field.
+func unknownKeys(node *yaml.Node, t reflect.Type) []LoadIssue {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var issues []LoadIssue
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, known := fields[key.Value]
+			if !known {
+				issues = append(issues, newIssue(UnknownKeyIssue, key,
+					fmt.Sprintf("%q is not a valid key", key.Value)))
+				continue
+			}
+			issues = append(issues, unknownKeys(value, field)...)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for _, item := range node.Content {
+			issues = append(issues, unknownKeys(item, t.Elem())...)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			issues = append(issues, unknownKeys(node.Content[i+1], t.Elem())...)
+		}
+	}
+	return issues
+}
+
+// yamlFields maps the yaml keys of a struct to the types of the fields they decode into.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
 	}
-	return parse(content)
+	return fields
 }
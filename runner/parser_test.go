@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseString(t *testing.T) {
@@ -124,3 +125,44 @@ atomic_tests:
 	assert.Equal(t, "Test1", technique.AtomicTests[0].Name)
 	assert.Equal(t, "", technique.AtomicTests[2].Executor.Name)
 }
+
+func TestParseAndValidate(t *testing.T) {
+	testYaml := `---
+display_name: TestData
+atomic_tests:
+  - name: Test1
+    auto_generated_guid: 5859a680-2395-40a4-a491-693262ef3b80
+    supported_platforms:
+      - linux
+      - beos
+    input_arguments:
+      file_name:
+        description: filename
+        typ: Path
+  - name: Test2
+    executor:
+      name: sh
+      command: echo`
+	_, issues := parseAndValidate([]byte(testYaml))
+	require.Equal(t, 5, len(issues))
+	assert.Equal(t, LoadIssue{UnknownKeyIssue, 12, 9, `"typ" is not a valid key`}, issues[0])
+	assert.Equal(t, MissingTechniqueIDIssue, issues[1].Kind)
+	assert.Equal(t, LoadIssue{MissingExecutorIssue, 4, 5, `test "Test1" has no executor`}, issues[2])
+	assert.Equal(t, LoadIssue{InvalidPlatformIssue, 8, 9, `test "Test1" has invalid platform "beos"`}, issues[3])
+	assert.Equal(t, LoadIssue{MissingGUIDIssue, 13, 5, `test "Test2" has no auto_generated_guid`}, issues[4])
+}
+
+func TestParseAndValidate_SyntaxError(t *testing.T) {
+	testYaml := `---
+attack_technique: T9999
+atomic_tests:
+  - name: [Test1
+`
+	_, issues := parseAndValidate([]byte(testYaml))
+	require.Equal(t, 1, len(issues))
+	assert.Equal(t, ParseIssue, issues[0].Kind)
+	assert.NotZero(t, issues[0].Line)
+
+	_, err := parse([]byte(testYaml))
+	assert.Error(t, err)
+}
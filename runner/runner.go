@@ -2,13 +2,16 @@ package runner
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 
@@ -17,11 +20,37 @@ import (
 
 // Runner is an atomic red team test runner.
 type Runner struct {
+	// AtomicsFolder is the path to the atomics folder or to a .zip or .tar.gz
+	// release archive of atomic red team.
 	AtomicsFolder string
-	Logger        Logger
+	// AtomicsFS is used to load techniques instead of AtomicsFolder when set.
+	AtomicsFS fs.FS
+	// ExtractFolder is where the atomics are extracted when they are loaded from an archive
+	// or AtomicsFS and a test is run. A temporary folder is used when it is not set.
+	ExtractFolder string
+	// OverlayFolders are loaded after AtomicsFolder in precedence order. A later folder can
+	// add techniques, add tests to an existing technique or replace a test that has the
+	// same auto_generated_guid. Archives are supported like for AtomicsFolder.
+	OverlayFolders []string
+	// EnableCache caches parsed technique files between runs, so that only files whose size
+	// or modification time changed are parsed again. Only folders on disk are cached.
+	EnableCache bool
+	// CacheFile is where the catalog cache is kept when EnableCache is set. It defaults to a
+	// file in the user cache folder.
+	CacheFile string
+	// AttackBundle is the path to a MITRE ATT&CK STIX bundle like enterprise-attack.json.
+	// When set, techniques are enriched with tactics, sub-techniques and data sources.
+	AttackBundle string
+	Logger       Logger
+	// StrictLoad makes LoadTechniques fail when any technique file has issues instead
+	// of skipping the files that cannot be loaded.
+	StrictLoad bool
 
 	techniques map[string]*art.Technique
 	guids      map[string]*art.Test
+	executors  map[string]LauncherFactory
+
+	layers []*layer
 }
 
 // Logger interface that the users of Runner need to satisfy to enable debug logging.
@@ -35,29 +64,185 @@ func (ar *Runner) debugf(format string, args ...interface{}) {
 	}
 }
 
-// LoadTechniques loads the atomic tests into the runner.
-func (ar *Runner) LoadTechniques() error {
-	if ar.AtomicsFolder == "" {
-		return fmt.Errorf("set atomics folder before loading techniques")
+// LoadTechniques loads the atomic tests into the runner. The returned report lists the
+// problems found in every technique file and is returned even when loading fails.
+// Close should be called once the runner is no longer needed.
+func (ar *Runner) LoadTechniques() (*LoadReport, error) {
+	if ar.AtomicsFolder == "" && ar.AtomicsFS == nil {
+		return nil, fmt.Errorf("set atomics folder before loading techniques")
+	}
+	report := &LoadReport{}
+	base, err := ar.openLayer(ar.AtomicsFolder, ar.AtomicsFS, ar.ExtractFolder)
+	if err != nil {
+		return nil, err
+	}
+	layers := []*layer{base}
+	// the layers are released unless they replace the ones of the previous load, whose
+	// techniques stay usable when loading fails.
+	loaded := false
+	defer func() {
+		if !loaded {
+			_ = closeLayers(layers)
+		}
+	}()
+	for _, location := range ar.OverlayFolders {
+		overlay, err := ar.openLayer(location, nil, "")
+		if err != nil {
+			return report, err
+		}
+		layers = append(layers, overlay)
 	}
-	ar.debugf("loading techniques from directory %s", ar.AtomicsFolder)
-	techniques := ar.processYAMLFolder()
-	if len(techniques) == 0 {
-		return fmt.Errorf("unable to load techniques from directory %s", ar.AtomicsFolder)
+
+	var cache *catalogCache
+	cacheFile := ar.cachePath()
+	if cacheFile != "" {
+		cache = loadCatalogCache(cacheFile)
 	}
-	ar.techniques = make(map[string]*art.Technique)
+	merged := make(map[string]*art.Technique)
+	guids := make(map[string]guidOwner)
+	for _, l := range layers {
+		ar.debugf("loading techniques from %s", l.location)
+		// only folders on disk are cached, extracted layers get a new location every time.
+		layerCache := cache
+		if l.needsExtract {
+			layerCache = nil
+		}
+		techniques, layerReport, err := ar.processYAMLFolder(l.source, l.path, layerCache)
+		report.Files = append(report.Files, layerReport.Files...)
+		if err != nil {
+			return report, fmt.Errorf("unable to load techniques from %s: %s", l.location, err)
+		}
+		for index := range techniques {
+			if file, found := checkGUIDs(guids, &techniques[index], l.path); found {
+				report.Files = append(report.Files, file)
+			}
+			ar.mergeTechnique(merged, &techniques[index])
+		}
+	}
+	if cache != nil {
+		if err := cache.save(cacheFile); err != nil {
+			ar.debugf("unable to save catalog cache %s: %s", cacheFile, err)
+		}
+	}
+	if ar.StrictLoad {
+		if err := report.Err(); err != nil {
+			return report, err
+		}
+	}
+	if len(merged) == 0 {
+		return report, fmt.Errorf("unable to load techniques from %s", base.location)
+	}
+	if ar.AttackBundle != "" {
+		if err := ar.enrichTechniques(merged); err != nil {
+			return report, err
+		}
+	}
+	// the layers of a previous load are replaced, release their archives and extract folders.
+	if err := ar.Close(); err != nil {
+		ar.debugf("unable to close previously loaded atomics: %s", err)
+	}
+	ar.layers, loaded = layers, true
+	ar.techniques = merged
+	// duplicates have been reported already, the first test keeps the guid.
 	ar.guids = make(map[string]*art.Test)
+	for guid, owner := range guids {
+		ar.guids[guid] = owner.test
+	}
+	return report, nil
+}
 
-	for techID := range techniques {
-		tech := techniques[techID]
-		ar.techniques[tech.ID] = &tech
+// guidOwner records the test that first used a guid while loading techniques.
+type guidOwner struct {
+	path          string
+	techniqueID   string
+	testName      string
+	atomicsFolder string
+	test          *art.Test
+}
 
-		for testID := range tech.AtomicTests {
-			test := tech.AtomicTests[testID]
-			if test.AutoGeneratedGUID != "" {
-				ar.guids[test.AutoGeneratedGUID] = test
+// checkGUIDs reports tests of a technique whose guid is already used by another test. A test
+// in a later atomics folder may reuse the guid of a test of the same technique to replace it.
+func checkGUIDs(guids map[string]guidOwner, tech *art.Technique, atomicsFolder string) (FileReport, bool) {
+	file := FileReport{Path: tech.Path}
+	for _, test := range tech.AtomicTests {
+		guid := strings.ToLower(test.AutoGeneratedGUID)
+		if guid == "" {
+			continue
+		}
+		owner, found := guids[guid]
+		if found && (owner.atomicsFolder == atomicsFolder || owner.techniqueID != tech.ID) {
+			file.Issues = append(file.Issues, LoadIssue{
+				Kind: DuplicateGUIDIssue,
+				Message: fmt.Sprintf("auto_generated_guid %s of test %q is already used by test %q in %s",
+					test.AutoGeneratedGUID, test.Name, owner.testName, owner.path),
+			})
+			continue
+		}
+		guids[guid] = guidOwner{
+			path:          tech.Path,
+			techniqueID:   tech.ID,
+			testName:      test.Name,
+			atomicsFolder: atomicsFolder,
+			test:          test,
+		}
+	}
+	return file, len(file.Issues) > 0
+}
+
+// mergeTechnique adds a technique loaded from a layer to the techniques loaded so far. Tests of a
+// technique that is already known are appended, unless they replace an existing test with the same guid.
+func (ar *Runner) mergeTechnique(techniques map[string]*art.Technique, tech *art.Technique) {
+	existing, found := techniques[tech.ID]
+	if !found {
+		techniques[tech.ID] = tech
+		return
+	}
+	if existing.DisplayName == "" {
+		existing.DisplayName = tech.DisplayName
+	}
+	for _, test := range tech.AtomicTests {
+		replaced := false
+		for index, current := range existing.AtomicTests {
+			if test.AutoGeneratedGUID != "" && strings.EqualFold(test.AutoGeneratedGUID, current.AutoGeneratedGUID) {
+				ar.debugf("test %s from %s replaced by %s", current.AutoGeneratedGUID,
+					current.AtomicsFolder, test.AtomicsFolder)
+				existing.AtomicTests[index] = test
+				replaced = true
+				break
 			}
 		}
+		if !replaced {
+			existing.AtomicTests = append(existing.AtomicTests, test)
+		}
+	}
+}
+
+// Close releases the archives the techniques were loaded from and removes the temporary
+// folders they were extracted to.
+func (ar *Runner) Close() error {
+	err := closeLayers(ar.layers)
+	ar.layers = nil
+	return err
+}
+
+// closeLayers closes every layer and returns the first error.
+func closeLayers(layers []*layer) error {
+	var err error
+	for _, l := range layers {
+		if closeErr := l.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// extractAtomics extracts the layer a test was loaded from to the folder PathToAtomicsFolder
+// resolves to for the test, so that commands can use the payloads shipped with it.
+func (ar *Runner) extractAtomics(atomicTest *art.Test) error {
+	for _, l := range ar.layers {
+		if l.path == atomicTest.AtomicsFolder {
+			return l.extract(ar)
+		}
 	}
 	return nil
 }
@@ -97,9 +282,9 @@ func (ar *Runner) GetTestByIDAndIndex(techniqueID string, index int) (*art.Test,
 	return atomicTest, nil
 }
 
-// GetTestByGUID gets an atomic test by its autogenerated guid.
+// GetTestByGUID gets an atomic test by its autogenerated guid. Guids are not case sensitive.
 func (ar *Runner) GetTestByGUID(guid string) (*art.Test, error) {
-	test, ok := ar.guids[guid]
+	test, ok := ar.guids[strings.ToLower(guid)]
 	if !ok {
 		return nil, fmt.Errorf("no test with guid %s found", guid)
 	}
@@ -143,8 +328,20 @@ func (ar *Runner) GetTestByIDAndName(techniqueID string, wantName string) (*art.
 // BuildTest builds an atomic test and gets it ready for execution by substituting the
 // supplied input arguments in the commands. This method is helpful to dry run a test
 // and verify what will get executed. If nil is passed in as the arguments parameter, default
-// arguments provided with the test case are used.
+// arguments provided with the test case are used. Arguments that are not declared by the
+// test or do not match their declared type are reported together in an *ArgumentError.
 func (ar *Runner) BuildTest(atomicTest *art.Test, arguments map[string]string) (*BuiltTest, error) {
+	workdir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get working directory: %s", err)
+	}
+	return ar.buildTest(atomicTest, arguments, workdir, LocalTarget{})
+}
+
+// buildTest builds a test whose commands run in workdir on target. The launchers are
+// resolved on target, and PathToAtomicsFolder resolves to the folder the payloads are
+// copied to there when it has one.
+func (ar *Runner) buildTest(atomicTest *art.Test, arguments map[string]string, workdir string, target Target) (*BuiltTest, error) {
 	if atomicTest == nil {
 		return nil, fmt.Errorf("atomic test cannot be nil")
 	}
@@ -153,37 +350,61 @@ func (ar *Runner) BuildTest(atomicTest *art.Test, arguments map[string]string) (
 		TestName:    atomicTest.Name,
 		TestGUID:    atomicTest.AutoGeneratedGUID,
 		Executor:    atomicTest.Executor.Name,
-		Platform:    getCurrentPlatform(),
+		Platform:    target.platform(),
+	}
+	_, targetAtomics := target.folders()
+
+	// PathToAtomicsFolder resolves to the folder the test was loaded from
+	atomicsFolder := atomicTest.AtomicsFolder
+	if atomicsFolder == "" {
+		atomicsFolder = ar.AtomicsFolder
+	}
+	// a relative folder only works when the commands run in the working directory of the runner
+	if cwd, err := os.Getwd(); err == nil && workdir != cwd && atomicsFolder != "" && !filepath.IsAbs(atomicsFolder) {
+		atomicsFolder = filepath.Join(cwd, atomicsFolder)
+	}
+	if targetAtomics != "" {
+		atomicsFolder = targetAtomics
 	}
 
 	// build arguments for atomic test and clean up command
-	args := buildArguments(atomicTest.InputArguments, arguments, ar.AtomicsFolder)
-	commands, err := buildCommands(atomicTest.Executor.Command, args, ar.AtomicsFolder)
+	args, err := buildArguments(atomicTest.InputArguments, arguments, atomicsFolder)
+	if err != nil {
+		if argErr, ok := err.(*ArgumentError); ok {
+			argErr.TestName = atomicTest.Name
+		}
+		return bt, err
+	}
+	// default values can point to the working directory like PathToAtomicsFolder
+	for name, value := range args {
+		args[name] = strings.ReplaceAll(value, "#{"+workdirArgument+"}", workdir)
+	}
+	args[workdirArgument] = workdir
+	commands, err := buildCommands(atomicTest.Executor.Command, args, atomicsFolder)
 	if err != nil {
 		return bt, fmt.Errorf("failed to build command for test %q, %s", atomicTest.Name, err)
 	}
-	cleanupCommands, err := buildCommands(atomicTest.Executor.CleanupCommand, args, ar.AtomicsFolder)
+	cleanupCommands, err := buildCommands(atomicTest.Executor.CleanupCommand, args, atomicsFolder)
 	if err != nil {
 		return bt, fmt.Errorf("failed to build cleanup commands for test %q, %s", atomicTest.Name, err)
 	}
+	if atomicTest.Executor.Name == manualExecutor {
+		return ar.buildManualTest(bt, atomicTest, args, atomicsFolder, target)
+	}
 
 	// test support is checked at this point so that the partially built test is still
 	// useful for debugging even though it cannot be run on the current platform.
-	err = verifyTestIsSupported(atomicTest)
+	err = verifyTestIsSupported(atomicTest, bt.Platform)
 	if err != nil {
 		return bt, err
 	}
-	launcher, err := getLauncher(atomicTest.Executor.Name)
+	launcher, err := ar.launcher(atomicTest.Executor.Name, target)
 	if err != nil {
-		if err == errUnsupportedExecutor {
-			// TODO: we are not doing anything with this information for the time being. change or remove?
-		} else {
-			return bt, fmt.Errorf("error getting launcher: %s", err)
-		}
+		return bt, fmt.Errorf("error getting launcher: %s", err)
 	}
 
 	// build dependencies if any
-	depInfo, err := buildDependency(atomicTest, args, ar.AtomicsFolder)
+	depInfo, err := ar.buildDependency(atomicTest, args, atomicsFolder, target)
 	if err != nil {
 		return bt, fmt.Errorf("failed to build dependency: %s", err)
 	}
@@ -198,7 +419,28 @@ func (ar *Runner) BuildTest(atomicTest *art.Test, arguments map[string]string) (
 	return bt, nil
 }
 
-func buildDependency(atomicTest *art.Test, args map[string]string, atomicsFolder string) (*DependencyInfo, error) {
+// buildManualTest builds the steps of a test with the manual executor. Dependencies are
+// only built when they have their own executor.
+func (ar *Runner) buildManualTest(bt *BuiltTest, atomicTest *art.Test, args map[string]string, atomicsFolder string, target Target) (*BuiltTest, error) {
+	steps, err := buildCommands(atomicTest.Executor.Steps, args, atomicsFolder)
+	if err != nil {
+		return bt, fmt.Errorf("failed to build steps for test %q, %s", atomicTest.Name, err)
+	}
+	bt.Arguments = args
+	bt.Steps = parseSteps(steps)
+	if err := verifyPlatform(atomicTest, bt.Platform); err != nil {
+		return bt, err
+	}
+	if atomicTest.DependencyExecutorName != "" && atomicTest.DependencyExecutorName != manualExecutor {
+		bt.DependencyInfo, err = ar.buildDependency(atomicTest, args, atomicsFolder, target)
+		if err != nil {
+			return bt, fmt.Errorf("failed to build dependency: %s", err)
+		}
+	}
+	return bt, nil
+}
+
+func (ar *Runner) buildDependency(atomicTest *art.Test, args map[string]string, atomicsFolder string, target Target) (*DependencyInfo, error) {
 	// fallback to the atomic test executor if the optional dependency executor is not specified.
 	depExecutor := atomicTest.DependencyExecutorName
 	if depExecutor == "" {
@@ -209,17 +451,12 @@ func buildDependency(atomicTest *art.Test, args map[string]string, atomicsFolder
 	var depInfo *DependencyInfo
 	if len(atomicTest.Dependencies) > 0 {
 		depInfo = &DependencyInfo{
-			Executor:          depExecutor,
-			supportedExecutor: true,
+			Executor: depExecutor,
 		}
 		var err error
-		depInfo.Launcher, err = getLauncher(depExecutor)
+		depInfo.Launcher, err = ar.launcher(depExecutor, target)
 		if err != nil {
-			if err == errUnsupportedExecutor {
-				depInfo.supportedExecutor = false
-			} else {
-				return depInfo, fmt.Errorf("error getting dependency launcher: %s", err)
-			}
+			return depInfo, fmt.Errorf("error getting dependency launcher: %s", err)
 		}
 		for _, dependency := range atomicTest.Dependencies {
 			getPreReqCommand, err := buildCommands(dependency.GetPrereqCommand, args, atomicsFolder)
@@ -239,7 +476,7 @@ func buildDependency(atomicTest *art.Test, args map[string]string, atomicsFolder
 	return depInfo, nil
 }
 
-func handleDependency(ctx context.Context, bt *BuiltTest, rc *TestRunConfig) (*DependencyRunInfo, error) {
+func handleDependency(ctx context.Context, bt *BuiltTest, rc *TestRunConfig, run *testRun) (*DependencyRunInfo, error) {
 	depLauncher := bt.DependencyInfo.Launcher
 	dri := &DependencyRunInfo{
 		Launcher: strings.Join(depLauncher, " "),
@@ -249,7 +486,8 @@ func handleDependency(ctx context.Context, bt *BuiltTest, rc *TestRunConfig) (*D
 		// run prereq commands
 		var err error
 
-		depResult.PreReq, err = runCommands(ctx, depLauncher, dependency.PreReqCmds, rc.SplitCmdsByNewline)
+		depResult.PreReq, err = runCommands(ctx, depLauncher, dependency.PreReqCmds,
+			rc.commandConfig(PreReqPhase, run))
 
 		lastExitCode := depResult.PreReq[len(depResult.PreReq)-1].Result.ExitCode
 
@@ -263,7 +501,8 @@ func handleDependency(ctx context.Context, bt *BuiltTest, rc *TestRunConfig) (*D
 		// Exit code can also be negative when building or running the command fails
 		var gprErr error
 		if lastExitCode > 0 && (rc.EnableDependency || rc.EnableAll) {
-			depResult.GetPreReq, gprErr = runCommands(ctx, depLauncher, dependency.GetPreReqCmds, rc.SplitCmdsByNewline)
+			depResult.GetPreReq, gprErr = runCommands(ctx, depLauncher, dependency.GetPreReqCmds,
+				rc.commandConfig(GetPreReqPhase, run))
 		}
 		dri.Dependencies = append(dri.Dependencies, depResult)
 		if gprErr != nil {
@@ -273,6 +512,247 @@ func handleDependency(ctx context.Context, bt *BuiltTest, rc *TestRunConfig) (*D
 	return dri, nil
 }
 
+// testRun is what is prepared for a run of a test and shared by its phases.
+type testRun struct {
+	output *outputSink
+	// identity runs the dependencies and the test, cleanupIdentity runs the cleanup.
+	identity        *identity
+	cleanupIdentity *identity
+	// environments are the environments of the phases that do not inherit everything.
+	environments map[RunPhase]*PhaseEnvironment
+	// sandbox is the folder created for the run when rc.Sandbox is set, workdir is the
+	// working directory of the test commands.
+	sandbox string
+	workdir string
+	// cgroup contains all the processes of the run when rc.Cgroup is set.
+	cgroup *cgroup
+	// isolation are the namespaces the commands are run in, nil when they are not isolated.
+	// root keeps the changes made to an isolated root by every phase until close.
+	isolation *Isolation
+	root      string
+	// target is where the commands are run, nil for the host of the runner.
+	target Target
+}
+
+// newTestRun prepares a run of atomicTest. The sandbox of the run, if any, is removed by
+// close.
+func (rc *TestRunConfig) newTestRun(atomicTest *art.Test) (*testRun, error) {
+	run := &testRun{isolation: rc.isolation(atomicTest)}
+	if err := run.isolation.validate(); err != nil {
+		return nil, fmt.Errorf("isolation: %s", err)
+	}
+	if !isLocal(rc.Target) {
+		return rc.newRemoteTestRun(run)
+	}
+	var err error
+	if run.identity, err = resolveIdentity(rc.RunAs); err != nil {
+		return nil, err
+	}
+	run.cleanupIdentity = run.identity
+	if rc.CleanupRunAs != nil {
+		if run.cleanupIdentity, err = resolveIdentity(rc.CleanupRunAs); err != nil {
+			return nil, fmt.Errorf("cleanup: %s", err)
+		}
+	}
+	var base Environment
+	if rc.Sandbox {
+		if run.sandbox, err = createSandbox(rc.SandboxFolder, run.identity); err != nil {
+			return nil, err
+		}
+		base = sandboxEnvironment(run.sandbox)
+	}
+	for _, phase := range []RunPhase{PreReqPhase, GetPreReqPhase, TestPhase, CleanupPhase} {
+		id := run.identity
+		if phase == CleanupPhase {
+			id = run.cleanupIdentity
+		}
+		pe, err := base.merge(&rc.Environment).merge(rc.PhaseEnvironments[phase]).environment(id)
+		if err != nil {
+			_ = run.close(rc, nil)
+			return nil, fmt.Errorf("%s environment: %s", phase, err)
+		}
+		if pe != nil {
+			if run.environments == nil {
+				run.environments = make(map[RunPhase]*PhaseEnvironment)
+			}
+			run.environments[phase] = pe
+		}
+	}
+	if pe := run.environments[TestPhase]; pe != nil && pe.Dir != "" {
+		run.workdir = pe.Dir
+	} else if run.workdir, err = os.Getwd(); err != nil {
+		_ = run.close(rc, nil)
+		return nil, fmt.Errorf("unable to get working directory: %s", err)
+	}
+	if rc.Cgroup {
+		if run.cgroup, err = newCgroup(rc.CgroupParent); err != nil {
+			_ = run.close(rc, nil)
+			return nil, err
+		}
+	}
+	if run.isolation != nil && run.isolation.Root != HostRoot {
+		if run.root, err = createIsolatedRoot(rc.SandboxFolder, run.identity); err != nil {
+			_ = run.close(rc, nil)
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+// newRemoteTestRun prepares run for rc.Target, whose commands run in the folder of the
+// target with its environment.
+func (rc *TestRunConfig) newRemoteTestRun(run *testRun) (*testRun, error) {
+	var unsupported []string
+	for name, set := range map[string]bool{
+		"sandbox":     rc.Sandbox,
+		"run-as":      rc.RunAs != nil || rc.CleanupRunAs != nil,
+		"environment": !rc.Environment.isZero() || len(rc.PhaseEnvironments) > 0,
+		"limits":      !rc.Limits.isZero(),
+		"cgroup":      rc.Cgroup,
+		"isolation":   run.isolation != nil,
+	} {
+		if set {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, fmt.Errorf("%s not supported on target %s", strings.Join(unsupported, ", "), rc.Target)
+	}
+	run.target = rc.Target
+	run.workdir, _ = rc.Target.folders()
+	return run, nil
+}
+
+// isolation returns the isolation of atomicTest, the one set for its guid or technique id
+// in rc.TestIsolation or else rc.Isolation. It is nil when the test is not isolated.
+func (rc *TestRunConfig) isolation(atomicTest *art.Test) *Isolation {
+	iso := rc.Isolation
+	if byTechnique, ok := rc.TestIsolation[atomicTest.TechniqueID]; ok {
+		iso = byTechnique
+	}
+	for key, byGUID := range rc.TestIsolation {
+		if atomicTest.AutoGeneratedGUID != "" && strings.EqualFold(key, atomicTest.AutoGeneratedGUID) {
+			iso = byGUID
+		}
+	}
+	if iso.isZero() {
+		return nil
+	}
+	return iso
+}
+
+// close records in tri, when it is not nil, the processes left in the cgroup with its
+// usage and the contents of the sandbox. The processes are killed and the cgroup and the
+// isolated root are removed, and so is the sandbox unless rc.KeepSandbox is set.
+func (run *testRun) close(rc *TestRunConfig, tri *TestRunInfo) error {
+	var errs error
+	if run.cgroup != nil {
+		if tri != nil {
+			var err error
+			if tri.RemainingProcesses, err = run.cgroup.processes(); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			if tri.Cgroup, err = run.cgroup.stats(); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+		if err := run.cgroup.kill(); err != nil {
+			errs = multierror.Append(errs, err)
+		} else if err := run.cgroup.remove(); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if run.root != "" {
+		if err := removeIsolatedRoot(run.root); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if run.sandbox == "" {
+		return errs
+	}
+	if tri != nil {
+		var err error
+		if tri.WorkdirFiles, err = listSandbox(run.sandbox); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if rc.KeepSandbox && tri != nil {
+		return errs
+	}
+	if err := os.RemoveAll(run.sandbox); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("unable to remove sandbox: %s", err))
+	}
+	return errs
+}
+
+// commandConfig returns the configuration for the commands run in phase.
+func (rc *TestRunConfig) commandConfig(phase RunPhase, run *testRun) commandConfig {
+	cc := commandConfig{
+		splitCmds:   rc.SplitCmdsByNewline,
+		phase:       phase,
+		output:      run.output,
+		stdoutLimit: rc.MaxStdoutBytes,
+		stderrLimit: rc.MaxStderrBytes,
+		spillDir:    rc.OutputSpillFolder,
+		timeout:     rc.phaseTimeout(phase),
+		termination: terminationPolicy{signal: rc.TerminationSignal, gracePeriod: rc.TerminationGracePeriod},
+		launch:      launchConfig{identity: run.identity, cgroup: run.cgroup},
+		target:      run.target,
+	}
+	if phase == CleanupPhase {
+		cc.launch.identity = run.cleanupIdentity
+	}
+	if pe := run.environments[phase]; pe != nil {
+		cc.launch.env, cc.launch.dir = pe.env, pe.Dir
+	}
+	if run.isolation != nil {
+		cc.launch.isolation, cc.launch.root = run.isolation, run.root
+		if run.sandbox != "" {
+			cc.launch.shared = []string{run.sandbox}
+		}
+	}
+	if !rc.Limits.isZero() {
+		limits := rc.Limits
+		cc.launch.limits = &limits
+	}
+	if cc.termination.signal == 0 {
+		cc.termination.signal = syscall.SIGTERM
+	}
+	return cc
+}
+
+// DefaultGracePeriod is a sensible TerminationGracePeriod, it is the default of the command line.
+const DefaultGracePeriod = 5 * time.Second
+
+func (rc *TestRunConfig) phaseTimeout(phase RunPhase) time.Duration {
+	switch phase {
+	case PreReqPhase:
+		return rc.PreReqTimeout
+	case GetPreReqPhase:
+		return rc.GetPreReqTimeout
+	case TestPhase:
+		return rc.TestTimeout
+	case CleanupPhase:
+		return rc.cleanupTimeout()
+	}
+	return 0
+}
+
+// DefaultCleanupTimeout limits cleanup when no other timeout is set in TestRunConfig.
+const DefaultCleanupTimeout = 5 * time.Minute
+
+// cleanupTimeout returns the deadline of the cleanup commands, which is never zero since
+// cleanup does not run with the context of the test.
+func (rc *TestRunConfig) cleanupTimeout() time.Duration {
+	for _, timeout := range []time.Duration{rc.CleanupTimeout, rc.Timeout} {
+		if timeout > 0 {
+			return timeout
+		}
+	}
+	return DefaultCleanupTimeout
+}
+
 // RunTestErrorType is used to annotate errors generated when an atomic test is run.
 type RunTestErrorType string
 
@@ -282,6 +762,7 @@ const (
 	GetPreReqError                   = "getprereq"
 	AtomicTestError                  = "atomic test"
 	CleanupError                     = "cleanup"
+	ManualStepError                  = "manual steps"
 )
 
 // RunTestError represents an error generated while running an atomic test.
@@ -294,7 +775,10 @@ func (rte RunTestError) Error() string {
 	return fmt.Sprintf("%s failed: %s", rte.Type, rte.Err.Error())
 }
 
-// RunTest runs an atomic test.
+// RunTest runs an atomic test. The dependencies and the test are run with ctx, limited
+// by the timeouts in rc. Cleanup is not run with ctx so that it still runs when the test
+// timed out or ctx was canceled, it gets a deadline of its own instead, see
+// TestRunConfig.CleanupTimeout.
 func (ar *Runner) RunTest(ctx context.Context, atomicTest *art.Test, arguments map[string]string, rc *TestRunConfig) (*TestRunInfo, error) {
 	if rc == nil {
 		return nil, fmt.Errorf("test run config cannot be nil")
@@ -307,56 +791,133 @@ func (ar *Runner) RunTest(ctx context.Context, atomicTest *art.Test, arguments m
 		TechniqueID: atomicTest.TechniqueID,
 		TestName:    atomicTest.Name,
 		TestGUID:    atomicTest.AutoGeneratedGUID,
-		Platform:    getCurrentPlatform(),
+		Platform:    targetOf(rc.Target).platform(),
 		Executor:    atomicTest.Executor.Name,
 	}
-	err := verifyTestIsSupported(atomicTest)
+	manual := atomicTest.Executor.Name == manualExecutor && rc.StepConfirmer != nil
+	var err error
+	if manual {
+		err = verifyPlatform(atomicTest, tri.Platform)
+	} else {
+		err = verifyTestIsSupported(atomicTest, tri.Platform)
+	}
 	if err != nil {
 		return tri, err
 	}
 
-	bt, err := ar.BuildTest(atomicTest, arguments)
+	run, err := rc.newTestRun(atomicTest)
 	if err != nil {
 		return tri, err
 	}
+	if run.identity != nil {
+		tri.User = run.identity.String()
+	}
+	if run.cleanupIdentity != nil {
+		tri.CleanupUser = run.cleanupIdentity.String()
+	}
+	tri.Environment = run.environments
+	tri.Workdir = run.sandbox
+	tri.Isolation = run.isolation
+	if run.target != nil {
+		tri.Target = run.target.String()
+	}
+
+	err = ar.runTest(ctx, atomicTest, arguments, rc, run, tri)
+	if closeErr := run.close(rc, tri); closeErr != nil {
+		err = multierror.Append(err, closeErr)
+	}
+	return tri, err
+}
+
+// runTest builds and runs a test that has been verified, its results are added to tri.
+func (ar *Runner) runTest(ctx context.Context, atomicTest *art.Test, arguments map[string]string, rc *TestRunConfig, run *testRun, tri *TestRunInfo) error {
+	bt, err := ar.buildTest(atomicTest, arguments, run.workdir, targetOf(run.target))
+	if err != nil {
+		return err
+	}
+	if err := ar.extractAtomics(atomicTest); err != nil {
+		return fmt.Errorf("failed to extract atomics: %s", err)
+	}
+	if run.target != nil {
+		atomicsFolder := atomicTest.AtomicsFolder
+		if atomicsFolder == "" {
+			atomicsFolder = ar.AtomicsFolder
+		}
+		if err := run.target.copyPayloads(payloadTechniques(atomicTest, arguments), atomicsFolder); err != nil {
+			return err
+		}
+	}
 	tri.Arguments = bt.Arguments
 	tri.Launcher = bt.Launcher
+	run.output = newOutputSink(rc.OutputHandler, bt)
+	manual := atomicTest.Executor.Name == manualExecutor && rc.StepConfirmer != nil
+
+	// the budget applies to everything but the cleanup
+	if rc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rc.Timeout)
+		defer cancel()
+	}
 
 	if rc.EnableAll || rc.EnableDependency || rc.EnableCheckPreReq {
 		// handle dependencies if any
 		if bt.DependencyInfo != nil {
-			tri.DependencyInfo, err = handleDependency(ctx, bt, rc)
+			tri.DependencyInfo, err = handleDependency(ctx, bt, rc, run)
 			if err != nil {
-				return tri, err
+				return err
 			}
 		}
 	}
 
+	if manual {
+		if !(rc.EnableTest || rc.EnableAll) {
+			return nil
+		}
+		var stepsErr error
+		if tri.ManualSteps, stepsErr = runManualSteps(ctx, bt, rc.StepConfirmer); stepsErr != nil {
+			return RunTestError{ManualStepError, stepsErr}
+		}
+		return nil
+	}
+
 	var combinedErr error
 	if rc.EnableTest || rc.EnableAll {
 		var testErr error
 		// run the actual test commands
-		tri.AtomicTest, testErr = runCommands(ctx, bt.Launcher, bt.AtomicTestCommands, rc.SplitCmdsByNewline)
+		tri.AtomicTest, testErr = runCommands(ctx, bt.Launcher, bt.AtomicTestCommands,
+			rc.commandConfig(TestPhase, run))
 		if testErr != nil {
 			combinedErr = multierror.Append(combinedErr, RunTestError{AtomicTestError, testErr})
 		}
 	}
-	// run clean up even if the test fails
+	// run clean up even if the test fails. cleanup gets a fresh context since ctx has
+	// expired when the test timed out, its deadline comes from its command config.
 	if rc.EnableCleanup || (rc.EnableAll && bt.CleanupCommands != "") {
+		if run.cleanupIdentity != nil && run.cleanupIdentity != run.identity {
+			for _, dir := range []string{run.sandbox, run.root} {
+				if dir == "" {
+					continue
+				}
+				if err := handOverFolder(dir, run.cleanupIdentity); err != nil {
+					combinedErr = multierror.Append(combinedErr, RunTestError{CleanupError, err})
+				}
+			}
+		}
 		var cleanupErr error
-		tri.Cleanup, cleanupErr = runCommands(ctx, bt.Launcher, bt.CleanupCommands, rc.SplitCmdsByNewline)
+		tri.Cleanup, cleanupErr = runCommands(context.Background(), bt.Launcher, bt.CleanupCommands,
+			rc.commandConfig(CleanupPhase, run))
 		if cleanupErr != nil {
 			combinedErr = multierror.Append(combinedErr, RunTestError{CleanupError, cleanupErr})
 		}
 	}
-	return tri, combinedErr
+	return combinedErr
 }
 
 const (
-	windows = "windows"
-	macos   = "macos" // atomic red team uses the term macos instead of darwin to mark tests that run on mac
-	linux   = "linux"
-	darwin  = "darwin"
+	windowsPlatform = "windows"
+	macos           = "macos" // atomic red team uses the term macos instead of darwin to mark tests that run on mac
+	linux           = "linux"
+	darwin          = "darwin"
 )
 
 func getCurrentPlatform() string {
@@ -367,91 +928,76 @@ func getCurrentPlatform() string {
 	return platform
 }
 
-func getCMDPromptForPlatform() ([]string, error) {
-	platform := getCurrentPlatform()
+func getCMDPromptForPlatform(target Target) ([]string, error) {
+	platform := target.platform()
 	switch platform {
 	case linux:
 		return []string{"/bin/sh"}, nil
 	case macos:
 		return []string{"/bin/sh"}, nil
-	case windows:
+	case windowsPlatform:
 		return []string{"C:\\Windows\\System32\\cmd.exe"}, nil
 	default:
-		return []string{"/bin/sh"}, errUnsupportedExecutor
+		return nil, fmt.Errorf("command_prompt is not supported on %s", platform)
 	}
 }
 
-var errUnsupportedExecutor = errors.New("unsupported executor")
-
-func getLauncher(executorName string) ([]string, error) {
-	switch executorName {
-	case "command_prompt":
-		return getCMDPromptForPlatform()
-	case "powershell":
+// getPowerShellLauncher returns Windows PowerShell on windows and PowerShell Core from
+// PATH on the other platforms, since upstream atomics use the powershell executor for both.
+func getPowerShellLauncher(target Target) ([]string, error) {
+	platform := target.platform()
+	switch platform {
+	case windowsPlatform:
 		return []string{"C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\powershell.exe", "-Command", "-"}, nil
-	case "sh":
-		return []string{"/bin/sh"}, nil
-	case "bash":
-		return []string{"/bin/bash"}, nil
-	case "manual":
-		return []string{}, fmt.Errorf("test with manual executor cannot be run")
-	case "":
-		return []string{}, fmt.Errorf("executor name not provided")
 	default:
-		return []string{executorName}, errUnsupportedExecutor
+		launcher, err := pathLauncher("pwsh", "-Command", "-")(target)
+		if err != nil {
+			return nil, fmt.Errorf("powershell tests need PowerShell Core (pwsh) on %s: %s", platform, err)
+		}
+		return launcher, nil
 	}
 }
 
-func verifyTestIsSupported(atomicTest *art.Test) error {
+func verifyTestIsSupported(atomicTest *art.Test, platform string) error {
 	if atomicTest.Executor.Name == "" {
 		return fmt.Errorf("invalid executor")
 	}
-	if atomicTest.Executor.Name == "manual" {
+	if atomicTest.Executor.Name == manualExecutor {
 		return fmt.Errorf("manual tests cannot be run")
 	}
-	platform := getCurrentPlatform()
+	return verifyPlatform(atomicTest, platform)
+}
+
+// verifyPlatform checks that the test supports platform, the platform of the target the
+// test runs on.
+func verifyPlatform(atomicTest *art.Test, platform string) error {
 	for _, sp := range atomicTest.SupportedPlatforms {
 		if platform == sp {
 			return nil
 		}
 	}
-	return fmt.Errorf("%q is not a valid test for %s", atomicTest.Name, getCurrentPlatform())
+	return fmt.Errorf("%q is not a valid test for %s", atomicTest.Name, platform)
 }
 
 // Filter filters atomic techniques and tests based on a filter config and returns
-// a new list of techniques.
+// a new list of techniques. Techniques are selected with patterns, see
+// FilterConfig.Techniques.
 func (ar *Runner) Filter(fc *FilterConfig) []*art.Technique {
 	// atomic red team uses the platform name macos instead of darwin
 	if fc.Platform == darwin {
 		fc.Platform = macos
 	}
 	var filtered []*art.Technique
-	var wantTechniques []string
-	for _, tech := range fc.Techniques {
-		if tech == "" {
-			continue
-		}
-		// check if the techniqueID passed is valid
-		if _, valid := ar.techniques[tech]; !valid {
-			continue
-		}
-		wantTechniques = append(wantTechniques, tech)
-	}
-	// techniqueID's were passed but not valid
-	if len(fc.Techniques) > 0 && len(wantTechniques) == 0 {
-		return filtered
-	}
-
-	// no techniqueID's were passed, so
-	if len(wantTechniques) == 0 {
-		for _, technique := range ar.techniques {
-			wantTechniques = append(wantTechniques, technique.ID)
-		}
-	}
+	wantTechniques := ar.selectTechniques(fc.Techniques)
+	// keep the order stable, since techniques are stored in a map
+	sort.Strings(wantTechniques)
 
 	for _, wantTechnique := range wantTechniques {
 		var atomicTests []*art.Test
 		technique := ar.techniques[wantTechnique]
+		if len(fc.Tactics) > 0 && !hasTactic(technique, fc.Tactics) {
+			continue
+		}
 		// Apply the platform and manual test filters
 		for testID := range technique.AtomicTests {
 			test := technique.AtomicTests[testID]
@@ -478,49 +1024,130 @@ func (ar *Runner) Filter(fc *FilterConfig) []*art.Technique {
 				DisplayName: technique.DisplayName,
 				Path:        technique.Path,
 				AtomicTests: atomicTests,
+				Attack:      technique.Attack,
 			})
 		}
 	}
 	return filtered
 }
 
-// processYAMLFolder recursively parses all yaml technique files inside the atomics folder
-// and returns a list of techniques.
-func (ar *Runner) processYAMLFolder() []art.Technique {
-	location := ar.AtomicsFolder
-	var files []string
+// processYAMLFolder recursively parses all yaml technique files inside the atomics source
+// and returns a list of techniques along with a report of the issues found in each file.
+// Files that cannot be parsed or have no technique id are left out of the list.
+// Results are looked up in and added to cache when it is not nil.
+func (ar *Runner) processYAMLFolder(source atomicsSource, location string, cache *catalogCache) ([]art.Technique, *LoadReport, error) {
 	var techniques []art.Technique
-	err := filepath.Walk(location, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if path != location && info.IsDir() && !strings.HasPrefix(info.Name(), "T") {
-			ar.debugf("skipping subdirectory %s\n", path)
-			return filepath.SkipDir
-		}
-		if filepath.Ext(path) == ".yaml" {
-			files = append(files, path)
-		}
-		return nil
-	})
+	report := &LoadReport{}
+	files, err := source.yamlFiles()
 	if err != nil {
-		return techniques
+		return techniques, report, err
 	}
 
+	var absLocation string
+	if cache != nil {
+		if absLocation, err = filepath.Abs(location); err != nil {
+			return techniques, report, err
+		}
+	}
+	seen := make(map[string]bool)
 	for _, file := range files {
-		tests, err := parseYAMLFile(file)
-		if err != nil {
-			ar.debugf("unable to parse file %s: %s\n", file, err)
+		path := filepath.Join(location, filepath.FromSlash(file.path))
+		tests, issues, cached := art.Technique{}, []LoadIssue(nil), false
+		cacheKey := filepath.Join(absLocation, filepath.FromSlash(file.path))
+		if cache != nil {
+			seen[cacheKey] = true
+			tests, issues, cached = cache.lookup(cacheKey, file)
+		}
+		if !cached {
+			ar.debugf("loading yaml file %s\n", path)
+			content, err := file.read()
+			if err != nil {
+				return techniques, report, err
+			}
+			tests, issues = parseAndValidate(content)
+			if cache != nil {
+				cache.store(cacheKey, file, tests, issues)
+			}
+		}
+		skip := false
+		for _, issue := range issues {
+			if issue.Kind == ParseIssue || issue.Kind == MissingTechniqueIDIssue {
+				skip = true
+				break
+			}
+		}
+		if len(issues) > 0 {
+			report.Files = append(report.Files, FileReport{Path: path, Skipped: skip, Issues: issues})
+		}
+		if skip {
+			ar.debugf("unable to load file %s: %s\n", path, issues[0])
+			continue
 		}
 
-		ar.debugf("loading yaml file %s\n", file)
 		// handle extra fields that are not part of the standard atomic yaml specification.
 		// adding path and technique id to every test
-		tests.Path = file
+		tests.Path = path
 		for index := range tests.AtomicTests {
 			tests.AtomicTests[index].TechniqueID = tests.ID
+			tests.AtomicTests[index].AtomicsFolder = location
+			tests.AtomicTests[index].Path = path
 		}
 		techniques = append(techniques, tests)
 	}
-	return techniques
+	if cache != nil {
+		cache.prune(absLocation, seen)
+	}
+	return techniques, report, nil
+}
+
+// LoadIssueKind is used to classify the issues found while loading technique files.
+type LoadIssueKind string
+
+// Various LoadIssueKind's reported by LoadTechniques.
+const (
+	ParseIssue              LoadIssueKind = "parse error"
+	UnknownKeyIssue         LoadIssueKind = "unknown key"
+	MissingTechniqueIDIssue LoadIssueKind = "missing technique id"
+	MissingExecutorIssue    LoadIssueKind = "missing executor"
+	InvalidPlatformIssue    LoadIssueKind = "invalid platform"
+	MissingGUIDIssue        LoadIssueKind = "missing guid"
+	DuplicateGUIDIssue      LoadIssueKind = "duplicate guid"
+)
+
+// Warning reports whether the issue leaves the test usable. Warnings do not fail strict loads.
+func (li LoadIssue) Warning() bool {
+	return li.Kind == MissingGUIDIssue
+}
+
+func (li LoadIssue) Error() string {
+	if li.Line == 0 {
+		return fmt.Sprintf("%s: %s", li.Kind, li.Message)
+	}
+	if li.Column == 0 {
+		return fmt.Sprintf("line %d: %s: %s", li.Line, li.Kind, li.Message)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %s", li.Line, li.Column, li.Kind, li.Message)
+}
+
+// Err combines all the issues in the report into a single error. Every issue is prefixed
+// with its position in the file:line:column format. Warnings are left out. It returns nil
+// when no other issues were found.
+func (lr *LoadReport) Err() error {
+	var err error
+	for _, file := range lr.Files {
+		for _, issue := range file.Issues {
+			if issue.Warning() {
+				continue
+			}
+			pos := file.Path
+			if issue.Line > 0 {
+				pos = fmt.Sprintf("%s:%d", pos, issue.Line)
+			}
+			if issue.Column > 0 {
+				pos = fmt.Sprintf("%s:%d", pos, issue.Column)
+			}
+			err = multierror.Append(err, fmt.Errorf("%s: %s: %s", pos, issue.Kind, issue.Message))
+		}
+	}
+	return err
 }
@@ -0,0 +1,154 @@
+//go:build linux
+// +build linux
+
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejohn/go-atomic/art"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommands_Limits(t *testing.T) {
+	launcher, err := getLauncher("sh")
+	require.NoError(t, err)
+	run := func(command string, limits Limits) *CmdResult {
+		out, _ := runCommands(context.Background(), launcher, command, commandConfig{launch: launchConfig{limits: &limits}})
+		require.Equal(t, 1, len(out))
+		return out[0].Result
+	}
+
+	res := run("head -c 100000 /dev/zero > "+t.TempDir()+"/big", Limits{FileSize: 1000})
+	assert.Equal(t, []LimitKind{FileSizeLimit}, res.LimitsHit)
+
+	res = run("paste /dev/null /dev/null /dev/null /dev/null /dev/null /dev/null", Limits{OpenFiles: 6})
+	assert.Contains(t, res.Stderr, "Too many open files")
+	assert.Equal(t, []LimitKind{OpenFilesLimit}, res.LimitsHit)
+
+	start := time.Now()
+	res = run("while :; do :; done", Limits{CPUTime: time.Second})
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, "SIGXCPU", res.Signal)
+	assert.Equal(t, []LimitKind{CPUTimeLimit}, res.LimitsHit)
+
+	// limits that are not reached are not reported
+	res = run("echo done", Limits{CPUTime: time.Minute, OpenFiles: 64, FileSize: 1000})
+	assert.Equal(t, "done\n", res.Stdout)
+	assert.Nil(t, res.LimitsHit)
+}
+
+func TestRunTest_Cgroup(t *testing.T) {
+	cg, err := newCgroup("")
+	if err != nil {
+		t.Skipf("cgroups cannot be created: %s", err)
+	}
+	require.NoError(t, cg.remove())
+
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name:           "sh",
+			Command:        "setsid sleep 301 > /dev/null 2>&1 &\nsleep 302",
+			CleanupCommand: "setsid sleep 303 > /dev/null 2>&1 &\nsleep 0.1",
+		},
+	}
+	ar := Runner{}
+	rc := getDefaultRC()
+	rc.Cgroup = true
+	rc.TestTimeout = 200 * time.Millisecond
+	rc.TerminationGracePeriod = 100 * time.Millisecond
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.Error(t, err)
+
+	// the process that left the process group was killed with the test, the one started
+	// by cleanup was left running until the test was done and the cgroup was removed
+	require.Equal(t, 1, len(out.RemainingProcesses))
+	assert.Equal(t, "sleep 303", out.RemainingProcesses[0].Command)
+	require.NotNil(t, out.Cgroup)
+	assert.True(t, out.Cgroup.CPUTime > 0)
+	_, err = os.Stat(out.Cgroup.Path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunTest_Isolation(t *testing.T) {
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		AutoGeneratedGUID:  "a1b2c3d4-0000-0000-0000-000000000000",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name: "sh",
+			Command: "set -e\ntest \"$(id -u)\" = 0\ntest $$ = 1\ntest \"$(grep -c : /proc/net/dev)\" = 1\n" +
+				"echo test > #{go_atomic_workdir}/out.txt",
+		},
+	}
+	ar := Runner{}
+	rc := getDefaultRC()
+	rc.Sandbox = true
+	rc.Isolation = &Isolation{Namespaces: []Namespace{UserNamespace, MountNamespace, PIDNamespace, NetworkNamespace}}
+	rc.TestIsolation = map[string]*Isolation{strings.ToUpper(atomicTest.AutoGeneratedGUID): {}}
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.Error(t, err, "test is not isolated")
+	assert.Nil(t, out.Isolation)
+
+	rc.TestIsolation = nil
+	out, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skipf("namespaces cannot be created: %s", err)
+	}
+	require.NoError(t, err)
+	assert.Equal(t, "user,mount,pid,network", out.Isolation.String())
+
+	// the root is read-only except for the sandbox, or writable but thrown away
+	atomicTest.Executor.Command += "\ntouch /etc/go-atomic-isolation /tmp/go-atomic-isolation"
+	iso := *rc.Isolation
+	iso.Root = ReadOnlyRoot
+	rc.TestIsolation = map[string]*Isolation{"T9999": &iso}
+	out, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.Error(t, err)
+	require.Equal(t, 1, len(out.AtomicTest))
+	assert.Contains(t, out.AtomicTest[0].Result.Stderr, "Read-only file system")
+
+	// the changes are kept for the cleanup and removed with the run
+	iso.Root = OverlayRoot
+	atomicTest.Executor.CleanupCommand = "test -f /etc/go-atomic-isolation\ntest -f /tmp/go-atomic-isolation"
+	rc.SandboxFolder = t.TempDir()
+	out, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err, out.AtomicTest[0].Result.Stderr)
+	assert.Equal(t, []WorkdirEntry{{Path: "out.txt", Size: 5}}, out.WorkdirFiles)
+	require.Equal(t, 1, len(out.Cleanup))
+	assert.Equal(t, 0, out.Cleanup[0].Result.ExitCode, out.Cleanup[0].Result.Stderr)
+	for _, path := range []string{"/etc/go-atomic-isolation", "/tmp/go-atomic-isolation"} {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	left, err := ioutil.ReadDir(rc.SandboxFolder)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestParseIsolation(t *testing.T) {
+	iso, err := ParseIsolation("user, pid,overlay,mount")
+	require.NoError(t, err)
+	assert.Equal(t, &Isolation{Namespaces: []Namespace{UserNamespace, PIDNamespace, MountNamespace}, Root: OverlayRoot}, iso)
+	assert.Equal(t, "user,pid,mount,overlay", iso.String())
+
+	iso, err = ParseIsolation("none")
+	require.NoError(t, err)
+	assert.True(t, iso.isZero())
+
+	for _, value := range []string{"user,ipc", "user,read-only", "mount,overlay,read-only", ""} {
+		_, err = ParseIsolation(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -2,9 +2,11 @@ package runner
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -16,7 +18,7 @@ func newRunner(location string) (*Runner, error) {
 	ar := &Runner{
 		AtomicsFolder: location,
 	}
-	err := ar.LoadTechniques()
+	_, err := ar.LoadTechniques()
 	return ar, err
 }
 
@@ -207,8 +209,10 @@ func TestBuildTest(t *testing.T) {
 }
 
 func TestProcessYamlFolder(t *testing.T) {
-	ar := Runner{AtomicsFolder: filepath.Join(testFolder, "T9999")}
-	tests := ar.processYAMLFolder()
+	location := filepath.Join(testFolder, "T9999")
+	ar := Runner{AtomicsFolder: location}
+	tests, _, err := ar.processYAMLFolder(&fsSource{fsys: os.DirFS(location)}, location, nil)
+	require.NoError(t, err)
 	assert.Equal(t, 1, len(tests))
 	assert.NotNil(t, tests[0].Path)
 	assert.Equal(t, 4, len(tests[0].AtomicTests))
@@ -217,3 +221,112 @@ func TestProcessYamlFolder(t *testing.T) {
 		tests[0].AtomicTests[1].InputArguments["file_name"].Default)
 	assert.Equal(t, "", tests[0].AtomicTests[2].Executor.Name)
 }
+
+func TestLoadTechniques_Report(t *testing.T) {
+	ar := &Runner{AtomicsFolder: filepath.Join(testFolder, "invalid")}
+	report, err := ar.LoadTechniques()
+	require.NoError(t, err)
+	require.NotNil(t, report)
+	// the file with broken yaml is skipped, the one with schema issues is loaded
+	assert.Equal(t, 1, len(ar.GetAllTechniques()))
+	require.Equal(t, 2, len(report.Files))
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+	assert.True(t, report.Files[0].Skipped)
+	assert.Equal(t, ParseIssue, report.Files[0].Issues[0].Kind)
+	assert.Equal(t, 5, report.Files[0].Issues[0].Line)
+	assert.False(t, report.Files[1].Skipped)
+	assert.Equal(t, 3, len(report.Files[1].Issues))
+}
+
+func TestLoadTechniques_Strict(t *testing.T) {
+	ar := &Runner{AtomicsFolder: filepath.Join(testFolder, "invalid"), StrictLoad: true}
+	report, err := ar.LoadTechniques()
+	require.Error(t, err)
+	require.NotNil(t, report)
+	assert.Contains(t, err.Error(), filepath.Join(testFolder, "invalid", "T0002", "T0002.yaml")+":8:5: unknown key")
+	assert.Equal(t, 0, len(ar.GetAllTechniques()))
+}
+
+func TestLoadTechniques_Overlay(t *testing.T) {
+	overlay := filepath.Join(testFolder, "overlay")
+	ar := &Runner{AtomicsFolder: testFolder, OverlayFolders: []string{overlay}}
+	report, err := ar.LoadTechniques()
+	require.NoError(t, err)
+	// replacing a test by guid is not a duplicate
+	for _, file := range report.Files {
+		for _, issue := range file.Issues {
+			assert.NotEqual(t, DuplicateGUIDIssue, issue.Kind)
+		}
+	}
+	assert.Equal(t, 5, len(ar.GetAllTechniques()))
+
+	tech, err := ar.GetTechnique("T9999")
+	require.NoError(t, err)
+	require.Equal(t, 5, len(tech.AtomicTests))
+	// replaced tests keep their position, new tests are appended
+	assert.Equal(t, "Test1 Private", tech.AtomicTests[0].Name)
+	assert.Equal(t, overlay, tech.AtomicTests[0].AtomicsFolder)
+	assert.Equal(t, testFolder, tech.AtomicTests[1].AtomicsFolder)
+	assert.Equal(t, "Test5", tech.AtomicTests[4].Name)
+
+	test, err := ar.GetTestByGUID("5859A680-2395-40A4-A491-693262EF3B80")
+	require.NoError(t, err)
+	bt, err := ar.BuildTest(test, nil)
+	require.NoError(t, err)
+	assert.Equal(t, overlay+"/src/private.txt", bt.Arguments["file_name"])
+
+	test, err = ar.GetTestByIDAndIndex("T9998", 0)
+	require.NoError(t, err)
+	bt, err = ar.BuildTest(test, nil)
+	require.NoError(t, err)
+	assert.Equal(t, testFolder+"/src/test.txt", bt.Arguments["file_name"])
+
+	_, err = ar.GetTestByGUID("7d3b8f36-1c55-4a53-9a4e-2f3b9f0d8c11")
+	assert.NoError(t, err)
+}
+
+func TestLoadTechniques_DuplicateGUIDs(t *testing.T) {
+	ar := &Runner{AtomicsFolder: filepath.Join(testFolder, "duplicates")}
+	report, err := ar.LoadTechniques()
+	require.NoError(t, err)
+	var duplicates []LoadIssue
+	for _, file := range report.Files {
+		for _, issue := range file.Issues {
+			if issue.Kind == DuplicateGUIDIssue {
+				duplicates = append(duplicates, issue)
+			}
+		}
+	}
+	require.Equal(t, 2, len(duplicates))
+	assert.Contains(t, duplicates[0].Message, `test "Test2" is already used by test "Test1"`)
+
+	// the first test keeps the guid
+	test, err := ar.GetTestByGUID("9B7C4F0E-2d1a-4c3b-8e5f-6a7b8c9d0e01")
+	require.NoError(t, err)
+	assert.Equal(t, "T0003", test.TechniqueID)
+	assert.Equal(t, "Test1", test.Name)
+
+	ar.StrictLoad = true
+	_, err = ar.LoadTechniques()
+	assert.Error(t, err)
+}
+
+func TestCleanupTimeout(t *testing.T) {
+	assert.Equal(t, DefaultCleanupTimeout, (&TestRunConfig{}).phaseTimeout(CleanupPhase))
+	assert.Equal(t, DefaultCleanupTimeout, (&TestRunConfig{TestTimeout: time.Second}).phaseTimeout(CleanupPhase))
+	assert.Equal(t, time.Minute, (&TestRunConfig{Timeout: time.Minute}).phaseTimeout(CleanupPhase))
+	assert.Equal(t, time.Second, (&TestRunConfig{Timeout: time.Minute, CleanupTimeout: time.Second}).phaseTimeout(CleanupPhase))
+}
+
+func TestLimitsHit(t *testing.T) {
+	limits := &Limits{CPUTime: time.Second, FileSize: 1000}
+	assert.Nil(t, limitsHit(limits, &CmdResult{ExitCode: 152}))
+	assert.Nil(t, limitsHit(limits, &CmdResult{ExitCode: 153}))
+	assert.Nil(t, limitsHit(limits, &CmdResult{Signal: "SIGKILL", Killed: true}))
+	assert.Equal(t, []LimitKind{CPUTimeLimit}, limitsHit(limits, &CmdResult{Signal: "SIGXCPU"}))
+	assert.Equal(t, []LimitKind{CPUTimeLimit}, limitsHit(limits, &CmdResult{Signal: "SIGKILL"}))
+	assert.Equal(t, []LimitKind{FileSizeLimit}, limitsHit(limits, &CmdResult{ExitCode: 153, Stderr: "File size limit exceeded\n"}))
+	assert.Nil(t, limitsHit(&Limits{OpenFiles: 10}, &CmdResult{Signal: "SIGXCPU"}))
+}
@@ -1,9 +1,17 @@
+//go:build darwin || linux
 // +build darwin linux
 
 package runner
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -36,7 +44,7 @@ func TestRunCommands(t *testing.T) {
 	launcher, err := getLauncher("command_prompt")
 	require.NoError(t, err)
 	ctx, _ := getContextWithCancel(nil)
-	out, err := runCommands(ctx, launcher, "echo \"hello\"\necho \"world\"", false)
+	out, err := runCommands(ctx, launcher, "echo \"hello\"\necho \"world\"", commandConfig{})
 	require.NoError(t, err)
 	assert.Equal(t, "hello\nworld\n", out[0].Result.Stdout)
 }
@@ -46,7 +54,7 @@ func TestRunCommands_WithTimeoutSucceed(t *testing.T) {
 	require.NoError(t, err)
 	timeout := time.Second * 5
 	ctx, _ := getContextWithCancel(&timeout)
-	out, err := runCommands(ctx, launcher, "sleep 1\necho done", false)
+	out, err := runCommands(ctx, launcher, "sleep 1\necho done", commandConfig{})
 	require.NoError(t, err)
 	require.Equal(t, 1, len(out))
 	assert.Equal(t, "done\n", out[0].Result.Stdout)
@@ -58,13 +66,120 @@ func TestRunCommands_WithTimeoutFail(t *testing.T) {
 	require.NoError(t, err)
 	timeout := time.Second * 1
 	ctx, _ := getContextWithCancel(&timeout)
-	out, err := runCommands(ctx, launcher, "sleep 6\necho done\n", false)
+	out, err := runCommands(ctx, launcher, "sleep 6\necho done\n", commandConfig{})
 	require.Error(t, err)
 	assert.Equal(t, "command timed out", err.Error())
 	assert.Equal(t, "", out[0].Result.Stdout)
 	assert.Equal(t, -1, out[0].Result.ExitCode)
 }
 
+func TestRunCommands_PhaseTimeout(t *testing.T) {
+	launcher, err := getLauncher("sh")
+	require.NoError(t, err)
+	// the timeout applies to all the commands together, not to each of them
+	start := time.Now()
+	out, err := runCommands(context.Background(), launcher, "sleep 0.4\nsleep 0.4\nsleep 0.4",
+		commandConfig{splitCmds: true, timeout: 600 * time.Millisecond})
+	require.Error(t, err)
+	assert.Equal(t, 2, len(out))
+	assert.True(t, time.Since(start) < time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = runCommands(ctx, launcher, "sleep 1", commandConfig{})
+	require.Error(t, err)
+	assert.Equal(t, "command canceled", err.Error())
+}
+
+func TestRunCommands_Termination(t *testing.T) {
+	launcher, err := getLauncher("sh")
+	require.NoError(t, err)
+	policy := terminationPolicy{signal: syscall.SIGTERM, gracePeriod: 2 * time.Second}
+	cc := commandConfig{timeout: 300 * time.Millisecond, termination: policy}
+
+	out, err := runCommands(context.Background(), launcher, "sleep 5", cc)
+	require.Error(t, err)
+	assert.True(t, out[0].Result.Killed)
+	assert.Equal(t, -1, out[0].Result.ExitCode)
+	assert.Equal(t, "SIGTERM", out[0].Result.Signal)
+
+	// the launcher handles the signal and exits by itself within the grace period
+	out, err = runCommands(context.Background(), launcher, "trap 'echo stopping; exit 3' TERM\nsleep 5 & wait", cc)
+	require.Error(t, err)
+	assert.True(t, out[0].Result.Killed)
+	assert.Equal(t, "", out[0].Result.Signal)
+	assert.Equal(t, "stopping\n", out[0].Result.Stdout)
+
+	// the processes started by the launcher get the grace period even when it exits right away
+	out, err = runCommands(context.Background(), launcher,
+		"(trap 'sleep 0.2; echo flushed; exit 0' TERM; sleep 5 & wait) &\ntrap 'exit 3' TERM\nwait", cc)
+	require.Error(t, err)
+	assert.True(t, out[0].Result.Killed)
+	assert.Equal(t, "flushed\n", out[0].Result.Stdout)
+
+	// the launcher ignores the signal and is killed after the grace period
+	cc.termination.gracePeriod = 300 * time.Millisecond
+	out, err = runCommands(context.Background(), launcher, "trap '' TERM\nsleep 5", cc)
+	require.Error(t, err)
+	assert.True(t, out[0].Result.Killed)
+	assert.Equal(t, "SIGKILL", out[0].Result.Signal)
+}
+
+func TestParseSignal(t *testing.T) {
+	for name, expected := range map[string]syscall.Signal{
+		"SIGTERM": syscall.SIGTERM,
+		"int":     syscall.SIGINT,
+		"KILL":    syscall.SIGKILL,
+		"1":       syscall.SIGHUP,
+	} {
+		sig, err := ParseSignal(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, sig, name)
+	}
+	_, err := ParseSignal("SIGNOPE")
+	assert.Error(t, err)
+}
+
+func TestRunTest_CleanupAfterTimeout(t *testing.T) {
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name:           "sh",
+			Command:        "sleep 5",
+			CleanupCommand: "sleep 0.2; echo cleanup",
+		},
+	}
+	ar := Runner{}
+	for name, rc := range map[string]*TestRunConfig{
+		"test timeout": {EnableAll: true, TestTimeout: 200 * time.Millisecond},
+		"budget":       {EnableAll: true, Timeout: 200 * time.Millisecond, CleanupTimeout: 5 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "atomic test failed: command timed out")
+			require.Equal(t, 1, len(out.Cleanup))
+			assert.Equal(t, "cleanup\n", out.Cleanup[0].Result.Stdout)
+		})
+	}
+
+	// cleanup still runs when the context of the caller is done
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	out, err := ar.RunTest(ctx, &atomicTest, nil, getDefaultRC())
+	require.Error(t, err)
+	assert.Equal(t, "cleanup\n", out.Cleanup[0].Result.Stdout)
+
+	// cleanup has its own timeout
+	out, err = ar.RunTest(context.Background(), &atomicTest, nil,
+		&TestRunConfig{EnableCleanup: true, CleanupTimeout: 50 * time.Millisecond})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cleanup failed: command timed out")
+	assert.Equal(t, "", out.Cleanup[0].Result.Stdout)
+}
+
 func TestRunTest(t *testing.T) {
 	atomicTest, args := getMockTest()
 	ar := Runner{}
@@ -97,9 +212,7 @@ func TestRunTest_UnSupportedExecutor(t *testing.T) {
 		SupportedPlatforms: []string{getCurrentPlatform()},
 		InputArguments:     nil,
 		Executor: art.Executor{
-			// supported executors are [sh, bash, powershell, command_prompt].
-			// although sh is a supported executor, lookup for "/bin/sh" fails
-			// and execution falls back to line by line.
+			// executors are looked up by name, a path to a shell is not an executor
 			Name:              "/bin/sh",
 			ElevationRequired: false,
 			Command:           "echo test\necho test\nexit 123\n",
@@ -109,11 +222,117 @@ func TestRunTest_UnSupportedExecutor(t *testing.T) {
 	ctx, _ := getContextWithCancel(nil)
 	out, err := ar.RunTest(ctx, &atomicTest, nil, getDefaultRC())
 	require.Error(t, err)
+	assert.Equal(t, `error getting launcher: executor "/bin/sh" is not supported`, err.Error())
+	assert.Nil(t, out.AtomicTest)
+
+	// registered executors are supported
+	ar.RegisterExecutor("/bin/sh", func() ([]string, error) {
+		return []string{"/bin/sh"}, nil
+	})
+	out, err = ar.RunTest(ctx, &atomicTest, nil, getDefaultRC())
+	require.Error(t, err)
 	require.Equal(t, 1, len(out.AtomicTest))
 	assert.Equal(t, "test\ntest\n", out.AtomicTest[0].Result.Stdout)
 	assert.Equal(t, 123, out.AtomicTest[0].Result.ExitCode)
 }
 
+func TestRunTest_OutputHandler(t *testing.T) {
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name:           "sh",
+			Command:        "echo out\necho err 1>&2",
+			CleanupCommand: "echo cleanup",
+		},
+	}
+	var mu sync.Mutex
+	var lines []string
+	rc := getDefaultRC()
+	rc.OutputHandler = func(event OutputEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf("%s %s %s", event.Phase, event.Stream, event.Data))
+	}
+	ar := Runner{}
+	_, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err)
+	// all the output has been sent by the time RunTest returns
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{"test stdout out\n", "test stderr err\n", "cleanup stdout cleanup\n"}, lines)
+}
+
+func TestRunCommands_LargeOutput(t *testing.T) {
+	launcher, err := getLauncher("sh")
+	require.NoError(t, err)
+	out, err := runCommands(context.Background(), launcher, "head -c 3000000 /dev/zero; echo end", commandConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, 3000004, len(out[0].Result.Stdout))
+	assert.False(t, out[0].Result.StdoutTruncated)
+
+	out, err = runCommands(context.Background(), launcher, "echo start; head -c 3000000 /dev/zero; echo end",
+		commandConfig{stdoutLimit: 20})
+	require.NoError(t, err)
+	result := out[0].Result
+	assert.True(t, strings.HasPrefix(result.Stdout, "start\n"))
+	assert.True(t, strings.HasSuffix(result.Stdout, "end\n"))
+	assert.Equal(t, 20, len(result.Stdout))
+	assert.True(t, result.StdoutTruncated)
+	assert.Equal(t, int64(3000010-20), result.StdoutDroppedBytes)
+}
+
+func TestRunCommands_BackgroundProcess(t *testing.T) {
+	launcher, err := getLauncher("sh")
+	require.NoError(t, err)
+	start := time.Now()
+	// the background process keeps stdout open after the launcher exits
+	out, err := runCommands(context.Background(), launcher, "sleep 10 &\necho done", commandConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, "done\n", out[0].Result.Stdout)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestRunCommands_SlowOutputHandler(t *testing.T) {
+	launcher, err := getLauncher("sh")
+	require.NoError(t, err)
+	var lines int
+	first := true
+	// the launcher exits while the handler is still busy with the first line, the output
+	// left in the pipe must not be cut off.
+	sink := newOutputSink(func(event OutputEvent) {
+		if first {
+			first = false
+			time.Sleep(2 * outputDrainTimeout)
+		}
+		lines++
+	}, &BuiltTest{})
+	out, err := runCommands(context.Background(), launcher, "seq 1 2000\nsleep 0.1\nseq 2001 5000", commandConfig{output: sink})
+	require.NoError(t, err)
+	var expected strings.Builder
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&expected, "%d\n", i)
+	}
+	assert.Equal(t, expected.String(), out[0].Result.Stdout)
+	assert.Equal(t, 5000, lines)
+}
+
+func BenchmarkRunCommand_Output(b *testing.B) {
+	launcher, err := getLauncher("sh")
+	require.NoError(b, err)
+	const size = 8 << 20
+	command := fmt.Sprintf("head -c %d /dev/zero", size)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := runCommand(context.Background(), launcher, command, commandConfig{})
+		if err != nil || len(result.Stdout) != size {
+			b.Fatalf("unexpected result: %v", err)
+		}
+	}
+}
+
 func TestRunTest_RunConfigSplitLines(t *testing.T) {
 	atomicTest := art.Test{
 		TechniqueID:        "T9999",
@@ -122,10 +341,7 @@ func TestRunTest_RunConfigSplitLines(t *testing.T) {
 		SupportedPlatforms: []string{getCurrentPlatform()},
 		InputArguments:     nil,
 		Executor: art.Executor{
-			// supported executors are [sh, bash, powershell, command_prompt].
-			// although sh is a supported executor, lookup for "/bin/sh" fails
-			// and execution falls back to line by line.
-			Name:              "/bin/sh",
+			Name:              "sh",
 			ElevationRequired: false,
 			Command:           "echo test1\necho test2\nexit 123\n",
 		},
@@ -152,10 +368,7 @@ func TestRunTest_RunConfigCleanup(t *testing.T) {
 		SupportedPlatforms: []string{getCurrentPlatform()},
 		InputArguments:     nil,
 		Executor: art.Executor{
-			// supported executors are [sh, bash, powershell, command_prompt].
-			// although sh is a supported executor, lookup for "/bin/sh" fails
-			// and execution falls back to line by line.
-			Name:              "/bin/sh",
+			Name:              "sh",
 			ElevationRequired: false,
 			Command:           "echo command\n",
 			CleanupCommand:    "echo cleanup\n",
@@ -197,10 +410,7 @@ func TestRunTest_RunConfigDependency(t *testing.T) {
 			},
 		},
 		Executor: art.Executor{
-			// supported executors are [sh, bash, powershell, command_prompt].
-			// although sh is a supported executor, lookup for "/bin/sh" fails
-			// and execution falls back to line by line.
-			Name:              "/bin/sh",
+			Name:              "sh",
 			ElevationRequired: false,
 			Command:           "echo command\n",
 			CleanupCommand:    "echo cleanup\n",
@@ -241,7 +451,7 @@ func TestRunCommands_ExitCodeSuccess(t *testing.T) {
 	launcher, err := getLauncher("sh")
 	require.NoError(t, err)
 	ctx, _ := getContextWithCancel(nil)
-	res, err := runCommands(ctx, launcher, "exit 0", false)
+	res, err := runCommands(ctx, launcher, "exit 0", commandConfig{})
 	require.NoError(t, err)
 	require.Len(t, res, 1)
 	assert.Equal(t, 0, res[0].Result.ExitCode)
@@ -251,8 +461,262 @@ func TestRunCommands_ExitCodeFail(t *testing.T) {
 	launcher, err := getLauncher("sh")
 	require.NoError(t, err)
 	ctx, _ := getContextWithCancel(nil)
-	res, err := runCommands(ctx, launcher, "exit 123", false)
+	res, err := runCommands(ctx, launcher, "exit 123", commandConfig{})
 	require.Error(t, err)
 	require.Len(t, res, 1)
 	assert.Equal(t, 123, res[0].Result.ExitCode)
 }
+
+func TestRunTests(t *testing.T) {
+	// every test waits until all of them started, so they fail unless they run at the same time
+	barrier := t.TempDir()
+	waitForAll := "touch #{barrier}/%d\n" +
+		"for i in $(seq 100); do [ $(ls #{barrier} | wc -l) -eq 4 ] && break; sleep 0.1; done\n" +
+		"[ $(ls #{barrier} | wc -l) -eq 4 ] || exit 1\n"
+	var tests []TestRun
+	for index := 0; index < 4; index++ {
+		tests = append(tests, TestRun{
+			Test: &art.Test{
+				TechniqueID:        "T9999",
+				Name:               fmt.Sprintf("Test %d", index),
+				SupportedPlatforms: []string{getCurrentPlatform()},
+				InputArguments: map[string]art.Argument{
+					"delay":   {Default: "0.1"},
+					"barrier": {Default: barrier},
+				},
+				Executor: art.Executor{
+					Name:    "sh",
+					Command: fmt.Sprintf(waitForAll+"sleep #{delay}\necho %d", index, index),
+				},
+			},
+			// the first test finishes last
+			Arguments: map[string]string{"delay": fmt.Sprintf("%.1f", 0.4-float64(index)*0.1)},
+		})
+	}
+	ar := Runner{}
+	results := ar.RunTests(context.Background(), tests, getDefaultRC(), 4)
+	require.Equal(t, 4, len(results))
+	for index, result := range results {
+		require.NoError(t, result.Err)
+		assert.Equal(t, tests[index].Test, result.Test)
+		assert.Equal(t, fmt.Sprintf("%d\n", index), result.Info.AtomicTest[0].Result.Stdout)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = ar.RunTests(ctx, tests, getDefaultRC(), 2)
+	for _, result := range results {
+		assert.Equal(t, context.Canceled, result.Err)
+	}
+}
+
+func TestRunTests_SameTest(t *testing.T) {
+	// the test fails when it starts before the cleanup of a previous run removed the marker
+	atomicTest := &art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		InputArguments:     map[string]art.Argument{"marker": {Default: t.TempDir() + "/running"}},
+		Executor: art.Executor{
+			Name:           "sh",
+			Command:        "test ! -e #{marker} || exit 1\ntouch #{marker}\nsleep 0.1",
+			CleanupCommand: "sleep 0.1\nrm #{marker}",
+		},
+	}
+	tests := []TestRun{{Test: atomicTest}, {Test: atomicTest}, {Test: atomicTest}}
+	ar := Runner{}
+	for _, result := range ar.RunTests(context.Background(), tests, getDefaultRC(), 3) {
+		assert.NoError(t, result.Err)
+	}
+}
+
+func TestRunTest_PowerShellCore(t *testing.T) {
+	// a fake pwsh that runs the commands it reads from stdin with sh
+	folder := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(folder, "pwsh"), []byte("#!/bin/sh\nexec /bin/sh\n"), 0755))
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	require.NoError(t, os.Setenv("PATH", folder))
+
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name:    "powershell",
+			Command: "echo pwsh",
+		},
+	}
+	ar := Runner{}
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, getDefaultRC())
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(folder, "pwsh"), "-Command", "-"}, out.Launcher)
+	assert.Equal(t, "pwsh\n", out.AtomicTest[0].Result.Stdout)
+
+	require.NoError(t, os.Setenv("PATH", t.TempDir()))
+	_, err = ar.BuildTest(&atomicTest, nil)
+	require.Error(t, err)
+	assert.Equal(t, "error getting launcher: powershell tests need PowerShell Core (pwsh) on "+
+		getCurrentPlatform()+": pwsh was not found in PATH", err.Error())
+}
+
+func TestRunTest_RunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running commands as another user requires root")
+	}
+	nobody, err := resolveIdentity(&RunAs{User: "nobody"})
+	if err != nil {
+		t.Skip("no nobody user: ", err)
+	}
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name:           "sh",
+			Command:        "id -u; id -g; echo $HOME $USER",
+			CleanupCommand: "id -u",
+		},
+	}
+	ar := Runner{}
+	rc := getDefaultRC()
+	rc.RunAs = &RunAs{User: "nobody"}
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\n%d\n%s nobody\n", nobody.uid, nobody.gid, nobody.home),
+		out.AtomicTest[0].Result.Stdout)
+	assert.Equal(t, fmt.Sprintf("%d\n", nobody.uid), out.Cleanup[0].Result.Stdout)
+	assert.Equal(t, nobody.String(), out.User)
+	assert.Equal(t, nobody.String(), out.CleanupUser)
+
+	// cleanup runs as another account, here the current one
+	rc.RunAs = &RunAs{User: "nobody", Group: "0"}
+	rc.CleanupRunAs = &RunAs{User: "0"}
+	out, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out.AtomicTest[0].Result.Stdout, fmt.Sprintf("%d\n0\n", nobody.uid)))
+	assert.Equal(t, "0\n", out.Cleanup[0].Result.Stdout)
+
+	rc.RunAs = &RunAs{User: "go-atomic-missing-user"}
+	_, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	assert.EqualError(t, err, "unknown user go-atomic-missing-user")
+}
+
+func TestRunTest_SandboxCleanupRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running commands as another user requires root")
+	}
+	for _, name := range []string{"nobody", "daemon"} {
+		if _, err := resolveIdentity(&RunAs{User: name}); err != nil {
+			t.Skip("no ", name, " user: ", err)
+		}
+	}
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name:           "sh",
+			Command:        "mkdir logs\necho log > logs/test.log",
+			CleanupCommand: "echo cleanup > logs/test.log\nrm -r logs\ntouch cleanup.txt",
+		},
+	}
+	ar := Runner{}
+	rc := getDefaultRC()
+	rc.Sandbox = true
+	// the sandbox folder has to be reachable by both accounts
+	folder, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	require.NoError(t, os.Chmod(folder, 0755))
+	rc.SandboxFolder = folder
+	rc.RunAs = &RunAs{User: "nobody"}
+	rc.CleanupRunAs = &RunAs{User: "daemon"}
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err)
+	assert.Equal(t, []WorkdirEntry{{Path: "cleanup.txt"}}, out.WorkdirFiles)
+}
+
+func TestParseRunAs(t *testing.T) {
+	ra, err := ParseRunAs("nobody")
+	require.NoError(t, err)
+	assert.Equal(t, RunAs{User: "nobody"}, *ra)
+	ra, err = ParseRunAs("1000:wheel")
+	require.NoError(t, err)
+	assert.Equal(t, RunAs{User: "1000", Group: "wheel"}, *ra)
+	assert.Equal(t, "1000:wheel", ra.String())
+	for _, value := range []string{"", ":wheel", "nobody:"} {
+		_, err = ParseRunAs(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRunTest_Environment(t *testing.T) {
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		Executor: art.Executor{
+			Name:           "sh",
+			Command:        "echo $GO_ATOMIC_VAR $GO_ATOMIC_PHASE > relative.txt",
+			CleanupCommand: "cat ../test/relative.txt; echo $GO_ATOMIC_PHASE; pwd",
+		},
+	}
+	folder := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(folder, "test"), 0755))
+	require.NoError(t, os.Mkdir(filepath.Join(folder, "cleanup"), 0755))
+	rc := getDefaultRC()
+	rc.Environment = Environment{
+		Vars:      map[string]string{"GO_ATOMIC_VAR": "test", "GO_ATOMIC_PHASE": "all"},
+		Allowlist: []string{"PATH"},
+		Dir:       filepath.Join(folder, "test"),
+	}
+	rc.PhaseEnvironments = map[RunPhase]*Environment{
+		CleanupPhase: {Vars: map[string]string{"GO_ATOMIC_PHASE": "cleanup"}, Dir: filepath.Join(folder, "cleanup")},
+	}
+	ar := Runner{}
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err)
+	assert.Equal(t, "test all\ncleanup\n"+filepath.Join(folder, "cleanup")+"\n", out.Cleanup[0].Result.Stdout)
+
+	require.NotNil(t, out.Environment[TestPhase])
+	assert.Equal(t, filepath.Join(folder, "test"), out.Environment[TestPhase].Dir)
+	assert.Equal(t, map[string]string{"GO_ATOMIC_PHASE": "all", "GO_ATOMIC_VAR": "test"}, out.Environment[TestPhase].Vars)
+	assert.Equal(t, []string{"PATH"}, out.Environment[TestPhase].Allowlist)
+	assert.Equal(t, []string{"PATH"}, out.Environment[TestPhase].Inherited)
+	assert.Equal(t, "cleanup", out.Environment[CleanupPhase].Vars["GO_ATOMIC_PHASE"])
+}
+
+func TestRunTest_Sandbox(t *testing.T) {
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		InputArguments:     map[string]art.Argument{"output": {Type: "Path", Default: "#{go_atomic_workdir}/out.txt"}},
+		Executor: art.Executor{
+			Name: "sh",
+			Command: "test \"$(pwd)\" = #{go_atomic_workdir} && test \"$TMPDIR\" = #{go_atomic_workdir}\n" +
+				"echo test > #{output}\nmkdir logs\necho log > logs/test.log",
+			CleanupCommand: "rm #{output}",
+		},
+	}
+	ar := Runner{}
+	rc := getDefaultRC()
+	rc.Sandbox = true
+	rc.SandboxFolder = t.TempDir()
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out.Workdir, rc.SandboxFolder))
+	assert.Equal(t, out.Workdir+"/out.txt", out.Arguments["output"])
+	assert.Equal(t, []WorkdirEntry{{Path: "logs", IsDir: true}, {Path: "logs/test.log", Size: 4}}, out.WorkdirFiles)
+	_, err = os.Stat(out.Workdir)
+	assert.True(t, os.IsNotExist(err))
+
+	// every run gets its own sandbox which can be kept
+	rc.KeepSandbox = true
+	kept, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.NoError(t, err)
+	assert.NotEqual(t, out.Workdir, kept.Workdir)
+	_, err = os.Stat(filepath.Join(kept.Workdir, "logs", "test.log"))
+	assert.NoError(t, err)
+}
@@ -1,3 +1,4 @@
+//go:build windows
 // +build windows
 
 package runner
@@ -27,7 +28,7 @@ func TestRunCommands_Powershell(t *testing.T) {
 	launcher, err := getLauncher("powershell")
 	require.NoError(t, err)
 	ctx, _ := getContextWithCancel(nil)
-	out, err := runCommands(ctx, launcher, "echo \"hello\"\necho \"world\"", false)
+	out, err := runCommands(ctx, launcher, "echo \"hello\"\necho \"world\"", commandConfig{})
 	require.NoError(t, err)
 	assert.Equal(t, "hello\r\nworld\r\n", out[0].Result.Stdout)
 }
@@ -36,7 +37,7 @@ func TestRunCommands_PowershellExitCodeSuccess(t *testing.T) {
 	launcher, err := getLauncher("powershell")
 	require.NoError(t, err)
 	ctx, _ := getContextWithCancel(nil)
-	res, err := runCommands(ctx, launcher, "exit 0", false)
+	res, err := runCommands(ctx, launcher, "exit 0", commandConfig{})
 	require.NoError(t, err)
 	require.Len(t, res, 1)
 	assert.Equal(t, 0, res[0].Result.ExitCode)
@@ -46,7 +47,7 @@ func TestRunCommands_PowershellExitCodeFail(t *testing.T) {
 	launcher, err := getLauncher("powershell")
 	require.NoError(t, err)
 	ctx, _ := getContextWithCancel(nil)
-	res, err := runCommands(ctx, launcher, "exit 123", false)
+	res, err := runCommands(ctx, launcher, "exit 123", commandConfig{})
 	require.Error(t, err)
 	require.Len(t, res, 1)
 	assert.Equal(t, 123, res[0].Result.ExitCode)
@@ -57,7 +58,7 @@ func TestRunCommands_WithTimeoutSucceed(t *testing.T) {
 	require.NoError(t, err)
 	timeout := time.Second * 5
 	ctx, _ := getContextWithCancel(&timeout)
-	out, err := runCommands(ctx, launcher, "sleep 1\necho done", false)
+	out, err := runCommands(ctx, launcher, "sleep 1\necho done", commandConfig{})
 	require.NoError(t, err)
 	require.Equal(t, 1, len(out))
 	assert.Equal(t, "done\r\n", out[0].Result.Stdout)
@@ -69,7 +70,7 @@ func TestRunCommands_WithTimeoutFail(t *testing.T) {
 	require.NoError(t, err)
 	timeout := time.Second * 1
 	ctx, _ := getContextWithCancel(&timeout)
-	out, err := runCommands(ctx, launcher, "sleep 6\necho done\n", false)
+	out, err := runCommands(ctx, launcher, "sleep 6\necho done\n", commandConfig{})
 	require.Error(t, err)
 	assert.Equal(t, "command timed out", err.Error())
 	assert.Equal(t, "", out[0].Result.Stdout)
@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// workdirArgument is the placeholder commands use to refer to the working directory of the
+// test, like #{go_atomic_workdir}. It is the sandbox when TestRunConfig.Sandbox is set.
+const workdirArgument = "go_atomic_workdir"
+
+// builtinArguments are the placeholders provided by the runner instead of the test.
+var builtinArguments = map[string]bool{
+	workdirArgument: true,
+}
+
+// WorkdirEntry is a file or a folder left in the sandbox of a test. Path is relative to
+// the sandbox and uses forward slashes.
+type WorkdirEntry struct {
+	Path  string
+	Size  int64
+	IsDir bool `json:",omitempty"`
+}
+
+// createSandbox creates an empty folder for a run of a test inside folder, or inside the
+// default folder for temporary files when folder is empty. The folder is given to id so
+// that commands run as another user can write to it, see handOverFolder for cleanup.
+func createSandbox(folder string, id *identity) (string, error) {
+	dir, err := ioutil.TempDir(folder, "go-atomic-sandbox-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create sandbox: %s", err)
+	}
+	if id != nil {
+		if err := os.Chown(dir, int(id.uid), int(id.gid)); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", fmt.Errorf("unable to give sandbox to %s: %s", id, err)
+		}
+	}
+	return dir, nil
+}
+
+// handOverFolder gives a folder of the run, like the sandbox, and everything the commands
+// left in it to id. Cleanup run as another account than the test can then change and
+// remove what the test created.
+func handOverFolder(dir string, id *identity) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, int(id.uid), int(id.gid))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to give %s to %s: %s", dir, id, err)
+	}
+	return nil
+}
+
+// sandboxEnvironment runs commands inside the sandbox and points the usual variables for
+// temporary files to it.
+func sandboxEnvironment(dir string) Environment {
+	env := Environment{Dir: dir, Vars: map[string]string{"TMPDIR": dir}}
+	if getCurrentPlatform() == windowsPlatform {
+		env.Vars["TEMP"], env.Vars["TMP"] = dir, dir
+	}
+	return env
+}
+
+// listSandbox returns the contents of the sandbox sorted by path.
+func listSandbox(dir string) ([]WorkdirEntry, error) {
+	var entries []WorkdirEntry
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entry := WorkdirEntry{Path: filepath.ToSlash(rel), IsDir: info.IsDir()}
+		if !info.IsDir() {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return entries, fmt.Errorf("unable to list sandbox: %s", err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// techniquePattern is a parsed entry of FilterConfig.Techniques. Patterns are one of
+//
+//	T1003              the technique and all of its sub-techniques
+//	T1003.*            glob patterns, * and ? are supported
+//	T1055.001-T1055.004 an inclusive range of techniques
+//	!T1485             excludes the techniques matched by the rest of the pattern
+type techniquePattern struct {
+	exclude bool
+	glob    string
+	from    techniqueNumber
+	to      techniqueNumber
+	isRange bool
+}
+
+// techniqueNumber is the numeric form of a technique id used to compare ranges. Parent
+// techniques have a sub number of -1 so that they are ordered before their sub-techniques.
+type techniqueNumber struct {
+	main int
+	sub  int
+}
+
+func (tn techniqueNumber) less(other techniqueNumber) bool {
+	if tn.main != other.main {
+		return tn.main < other.main
+	}
+	return tn.sub < other.sub
+}
+
+// parseTechniqueNumber parses ids like T1003 and T1003.001.
+func parseTechniqueNumber(id string) (techniqueNumber, bool) {
+	if len(id) < 2 || id[0] != 'T' {
+		return techniqueNumber{}, false
+	}
+	parts := strings.SplitN(id[1:], ".", 2)
+	main, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return techniqueNumber{}, false
+	}
+	tn := techniqueNumber{main: main, sub: -1}
+	if len(parts) == 2 {
+		if tn.sub, err = strconv.Atoi(parts[1]); err != nil {
+			return techniqueNumber{}, false
+		}
+	}
+	return tn, true
+}
+
+func parseTechniquePattern(pattern string) (techniquePattern, error) {
+	tp := techniquePattern{}
+	pattern = strings.ToUpper(strings.TrimSpace(pattern))
+	if strings.HasPrefix(pattern, "!") {
+		tp.exclude = true
+		pattern = strings.TrimSpace(pattern[1:])
+	}
+	if pattern == "" {
+		return tp, fmt.Errorf("empty technique pattern")
+	}
+	if index := strings.Index(pattern, "-"); index >= 0 {
+		from, okFrom := parseTechniqueNumber(strings.TrimSpace(pattern[:index]))
+		to, okTo := parseTechniqueNumber(strings.TrimSpace(pattern[index+1:]))
+		if !okFrom || !okTo {
+			return tp, fmt.Errorf("invalid technique range %q", pattern)
+		}
+		if to.less(from) {
+			return tp, fmt.Errorf("technique range %q is reversed", pattern)
+		}
+		tp.from, tp.to, tp.isRange = from, to, true
+		return tp, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return tp, fmt.Errorf("invalid technique pattern %q: %s", pattern, err)
+	}
+	tp.glob = pattern
+	return tp, nil
+}
+
+// matches reports whether the technique or its parent technique is selected by the pattern.
+func (tp techniquePattern) matches(techniqueID string) bool {
+	id := strings.ToUpper(techniqueID)
+	if tp.matchesID(id) {
+		return true
+	}
+	if index := strings.Index(id, "."); index > 0 {
+		return tp.matchesID(id[:index])
+	}
+	return false
+}
+
+func (tp techniquePattern) matchesID(id string) bool {
+	if !tp.isRange {
+		matched, _ := path.Match(tp.glob, id)
+		return matched
+	}
+	tn, ok := parseTechniqueNumber(id)
+	if !ok {
+		return false
+	}
+	return !tn.less(tp.from) && !tp.to.less(tn)
+}
+
+// ValidateTechniquePatterns checks the syntax of technique patterns that can be used in
+// FilterConfig.Techniques. Filter ignores patterns that are not valid.
+func ValidateTechniquePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		if _, err := parseTechniquePattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectTechniques returns the ids of the techniques selected by the patterns. When there
+// are only exclusions, they are applied to all the techniques.
+func (ar *Runner) selectTechniques(patterns []string) []string {
+	var includes, excludes []techniquePattern
+	// patterns that include techniques were passed, even if none of them are valid
+	wantIncludes := false
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if !strings.HasPrefix(pattern, "!") {
+			wantIncludes = true
+		}
+		tp, err := parseTechniquePattern(pattern)
+		if err != nil {
+			ar.debugf("ignoring technique pattern: %s", err)
+			continue
+		}
+		if tp.exclude {
+			excludes = append(excludes, tp)
+		} else {
+			includes = append(includes, tp)
+		}
+	}
+
+	var selected []string
+	for id := range ar.techniques {
+		included := !wantIncludes
+		for _, tp := range includes {
+			if tp.matches(id) {
+				included = true
+				break
+			}
+		}
+		for _, tp := range excludes {
+			if tp.matches(id) {
+				included = false
+				break
+			}
+		}
+		if included {
+			selected = append(selected, id)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+func newSelectorRunner() *Runner {
+	ar := &Runner{techniques: make(map[string]*art.Technique)}
+	for _, id := range []string{"T1003", "T1003.001", "T1003.002", "T1055", "T1055.001",
+		"T1055.002", "T1055.004", "T1055.005", "T1485", "T1486"} {
+		ar.techniques[id] = &art.Technique{ID: id}
+	}
+	return ar
+}
+
+func TestSelectTechniques(t *testing.T) {
+	var test = []struct {
+		patterns []string
+		want     []string
+	}{
+		{[]string{"T1003"}, []string{"T1003", "T1003.001", "T1003.002"}},
+		{[]string{"t1003.001"}, []string{"T1003.001"}},
+		{[]string{"T1003.*"}, []string{"T1003.001", "T1003.002"}},
+		{[]string{"T1055.001-T1055.004"}, []string{"T1055.001", "T1055.002", "T1055.004"}},
+		{[]string{"T1003-T1055"}, []string{"T1003", "T1003.001", "T1003.002", "T1055",
+			"T1055.001", "T1055.002", "T1055.004", "T1055.005"}},
+		{[]string{"T14*", "!T1485"}, []string{"T1486"}},
+		{[]string{"!T1003", "!T1055"}, []string{"T1485", "T1486"}},
+		{[]string{"T1003", "!T1003.002"}, []string{"T1003", "T1003.001"}},
+		{[]string{"T9999"}, nil},
+		{[]string{"T1055.004-T1055.001"}, nil},
+	}
+	ar := newSelectorRunner()
+	for _, tt := range test {
+		got := ar.selectTechniques(tt.patterns)
+		sort.Strings(got)
+		assert.Equal(t, tt.want, got, "patterns %v", tt.patterns)
+	}
+}
+
+func TestValidateTechniquePatterns(t *testing.T) {
+	require.NoError(t, ValidateTechniquePatterns([]string{"T1003", "T1003.*", "!T1485", "T1055.001-T1055.004", ""}))
+	assert.Error(t, ValidateTechniquePatterns([]string{"T1055.004-T1055.001"}))
+	assert.Error(t, ValidateTechniquePatterns([]string{"T1055-foo"}))
+	assert.Error(t, ValidateTechniquePatterns([]string{"T1003.["}))
+	assert.Error(t, ValidateTechniquePatterns([]string{"!"}))
+}
+
+func TestFilter_TechniquePatterns(t *testing.T) {
+	ar, err := newRunner(testFolder)
+	require.NoError(t, err)
+	out := ar.Filter(&FilterConfig{Techniques: []string{"T999*", "!T9996"}, IncludeManual: true})
+	require.Equal(t, 3, len(out))
+	assert.Equal(t, "T9997", out[0].ID)
+	assert.Equal(t, "T9999", out[2].ID)
+}
@@ -0,0 +1,392 @@
+package runner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// yamlFile is a technique file found in an atomics source. Path is slash separated
+// and relative to the atomics root. The content is only read when it is needed.
+type yamlFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	read    func() ([]byte, error)
+}
+
+// atomicsSource abstracts the location techniques are loaded from so that plain
+// directories, fs.FS implementations and release archives can be treated the same.
+type atomicsSource interface {
+	yamlFiles() ([]yamlFile, error)
+	// extract writes the contents of the atomics root into dir.
+	extract(dir string) error
+	close() error
+}
+
+// layer is one of the atomics folders loaded by the runner.
+type layer struct {
+	// location is the folder or archive as configured and is only used in messages.
+	location string
+	source   atomicsSource
+	// path is the folder PathToAtomicsFolder resolves to for tests from this layer.
+	path         string
+	needsExtract bool
+	ownsExtract  bool
+	extractOnce  sync.Once
+	extractErr   error
+}
+
+// openLayer opens the atomics found at location, or in fsys when it is set. Layers that
+// cannot be used by commands as they are get extracted to extractFolder, or to a
+// temporary folder when extractFolder is empty.
+func (ar *Runner) openLayer(location string, fsys fs.FS, extractFolder string) (*layer, error) {
+	l := &layer{location: location, path: location}
+	if fsys != nil {
+		l.location = "file system"
+	}
+	var err error
+	l.source, l.needsExtract, err = openSource(location, fsys)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open atomics %s: %s", l.location, err)
+	}
+	if !l.needsExtract {
+		return l, nil
+	}
+	l.path = extractFolder
+	if l.path == "" {
+		// only the folder is created here, the files are extracted on the first test run.
+		l.path, err = ioutil.TempDir("", "go-atomic")
+		if err != nil {
+			_ = l.source.close()
+			return nil, err
+		}
+		l.ownsExtract = true
+	}
+	return l, nil
+}
+
+// extract writes the files of the layer to its path. It only does work once.
+func (l *layer) extract(ar *Runner) error {
+	if !l.needsExtract {
+		return nil
+	}
+	l.extractOnce.Do(func() {
+		ar.debugf("extracting atomics %s to %s", l.location, l.path)
+		if err := os.MkdirAll(l.path, 0755); err != nil {
+			l.extractErr = err
+			return
+		}
+		l.extractErr = l.source.extract(l.path)
+	})
+	return l.extractErr
+}
+
+func (l *layer) close() error {
+	err := l.source.close()
+	if l.ownsExtract {
+		if rmErr := os.RemoveAll(l.path); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// openSource returns the source for the atomics at location, or fsys when it is set,
+// along with a flag that is set when the files have to be extracted before they can be
+// used by commands.
+func openSource(location string, fsys fs.FS) (atomicsSource, bool, error) {
+	if fsys != nil {
+		return &fsSource{fsys: fsys, findRoot: true}, true, nil
+	}
+	switch {
+	case strings.HasSuffix(location, ".zip"):
+		zr, err := zip.OpenReader(location)
+		if err != nil {
+			return nil, false, err
+		}
+		return &fsSource{fsys: zr, closer: zr, findRoot: true}, true, nil
+	case strings.HasSuffix(location, ".tar.gz"), strings.HasSuffix(location, ".tgz"):
+		return &tarSource{archive: location}, true, nil
+	}
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return nil, false, fmt.Errorf("%s is not a directory or a supported archive", location)
+	}
+	return &fsSource{fsys: os.DirFS(location)}, false, nil
+}
+
+// fsSource loads techniques from an fs.FS. When findRoot is set the atomics folder is
+// searched for inside the file system, which is how release archives and embedded
+// copies of atomic red team are laid out.
+type fsSource struct {
+	fsys     fs.FS
+	closer   io.Closer
+	findRoot bool
+}
+
+func (fss *fsSource) root() (fs.FS, error) {
+	if !fss.findRoot {
+		return fss.fsys, nil
+	}
+	var paths []string
+	err := fs.WalkDir(fss.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	root := atomicsRoot(paths)
+	if root == "." {
+		return fss.fsys, nil
+	}
+	return fs.Sub(fss.fsys, root)
+}
+
+func (fss *fsSource) yamlFiles() ([]yamlFile, error) {
+	fsys, err := fss.root()
+	if err != nil {
+		return nil, err
+	}
+	var files []yamlFile
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != "." && d.IsDir() && !strings.HasPrefix(d.Name(), "T") {
+			return fs.SkipDir
+		}
+		if path.Ext(p) != ".yaml" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, yamlFile{
+			path:    p,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			read: func() ([]byte, error) {
+				return fs.ReadFile(fsys, p)
+			},
+		})
+		return nil
+	})
+	return files, err
+}
+
+func (fss *fsSource) extract(dir string) error {
+	fsys, err := fss.root()
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(p))
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		src, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return writeFile(dest, src, info.Mode().Perm())
+	})
+}
+
+func (fss *fsSource) close() error {
+	if fss.closer == nil {
+		return nil
+	}
+	return fss.closer.Close()
+}
+
+// tarSource loads techniques from a gzip compressed tar archive. Tar archives cannot be
+// read at random, so the archive is streamed from disk every time it is accessed.
+type tarSource struct {
+	archive string
+}
+
+func (ts *tarSource) walk(fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(ts.archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// root returns the location of the atomics folder inside the archive.
+func (ts *tarSource) root() (string, error) {
+	var paths []string
+	err := ts.walk(func(hdr *tar.Header, r io.Reader) error {
+		paths = append(paths, path.Clean(hdr.Name))
+		return nil
+	})
+	return atomicsRoot(paths), err
+}
+
+func (ts *tarSource) yamlFiles() ([]yamlFile, error) {
+	root, err := ts.root()
+	if err != nil {
+		return nil, err
+	}
+	var files []yamlFile
+	err = ts.walk(func(hdr *tar.Header, r io.Reader) error {
+		rel, ok := relativeTo(root, path.Clean(hdr.Name))
+		if !ok || hdr.Typeflag != tar.TypeReg || !isTechniqueFile(rel) {
+			return nil
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		files = append(files, yamlFile{
+			path:    rel,
+			size:    hdr.Size,
+			modTime: hdr.ModTime,
+			read: func() ([]byte, error) {
+				return content, nil
+			},
+		})
+		return nil
+	})
+	return files, err
+}
+
+func (ts *tarSource) extract(dir string) error {
+	root, err := ts.root()
+	if err != nil {
+		return err
+	}
+	return ts.walk(func(hdr *tar.Header, r io.Reader) error {
+		rel, ok := relativeTo(root, path.Clean(hdr.Name))
+		if !ok || !fs.ValidPath(rel) {
+			return nil
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(rel))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			return os.MkdirAll(dest, 0755)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			return writeFile(dest, r, os.FileMode(hdr.Mode).Perm())
+		}
+		// links and special files are not needed by atomic tests
+		return nil
+	})
+}
+
+func (ts *tarSource) close() error {
+	return nil
+}
+
+// atomicsRoot returns the shallowest folder named atomics in a list of slash separated
+// paths. If there is none, the root of the paths is assumed to be the atomics folder.
+func atomicsRoot(paths []string) string {
+	root := "."
+	for _, p := range paths {
+		var candidate string
+		if path.Base(p) == "atomics" {
+			candidate = p
+		} else if index := strings.Index(p, "/atomics/"); index >= 0 {
+			candidate = p[:index+len("/atomics")]
+		} else if strings.HasPrefix(p, "atomics/") {
+			candidate = "atomics"
+		} else {
+			continue
+		}
+		if root == "." || strings.Count(candidate, "/") < strings.Count(root, "/") {
+			root = candidate
+		}
+	}
+	return root
+}
+
+// relativeTo returns p relative to root, both slash separated.
+func relativeTo(root, p string) (string, bool) {
+	if root == "." {
+		return p, true
+	}
+	if p == root {
+		return ".", true
+	}
+	if !strings.HasPrefix(p, root+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(p, root+"/"), true
+}
+
+// isTechniqueFile applies the same rules as the folder walk: the file has to be a yaml
+// file and every folder leading to it has to start with T.
+func isTechniqueFile(rel string) bool {
+	if path.Ext(rel) != ".yaml" {
+		return false
+	}
+	dirs := strings.Split(path.Dir(rel), "/")
+	for _, dir := range dirs {
+		if dir != "." && !strings.HasPrefix(dir, "T") {
+			return false
+		}
+	}
+	return true
+}
+
+func writeFile(dest string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
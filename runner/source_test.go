@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const archivePrefix = "atomic-red-team-master/atomics/"
+
+// testdataFiles returns the contents of the testdata folder keyed by slash separated path.
+func testdataFiles(t *testing.T) map[string][]byte {
+	files := make(map[string][]byte)
+	err := filepath.Walk(testFolder, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(testFolder, p)
+		files[filepath.ToSlash(rel)] = content
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func writeZip(t *testing.T, dir string) string {
+	archive := filepath.Join(dir, "atomics.zip")
+	f, err := os.Create(archive)
+	require.NoError(t, err)
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range testdataFiles(t) {
+		w, err := zw.Create(archivePrefix + name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return archive
+}
+
+func writeTarGz(t *testing.T, dir string) string {
+	archive := filepath.Join(dir, "atomics.tar.gz")
+	f, err := os.Create(archive)
+	require.NoError(t, err)
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range testdataFiles(t) {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     archivePrefix + name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		require.NoError(t, err)
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return archive
+}
+
+func TestLoadTechniques_Archives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, archive := range []string{writeZip(t, dir), writeTarGz(t, dir)} {
+		t.Run(filepath.Base(archive), func(t *testing.T) {
+			ar := &Runner{AtomicsFolder: archive}
+			_, err := ar.LoadTechniques()
+			require.NoError(t, err)
+			assert.Equal(t, 4, len(ar.GetAllTechniques()))
+
+			test, err := ar.GetTestByIDAndIndex("T9999", 0)
+			require.NoError(t, err)
+			bt, err := ar.BuildTest(test, nil)
+			require.NoError(t, err)
+			extracted := test.AtomicsFolder
+			assert.Equal(t, path.Join(extracted, "src/test.txt"), bt.Arguments["file_name"])
+
+			// nothing is extracted until a test needs it
+			_, err = os.Stat(filepath.Join(extracted, "T9999", "T9999.yaml"))
+			assert.True(t, os.IsNotExist(err))
+			require.NoError(t, ar.extractAtomics(test))
+			_, err = os.Stat(filepath.Join(extracted, "T9999", "T9999.yaml"))
+			assert.NoError(t, err)
+
+			require.NoError(t, ar.Close())
+			_, err = os.Stat(extracted)
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestLoadTechniques_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ar := &Runner{AtomicsFolder: writeZip(t, dir)}
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	first := ar.layers[0].path
+
+	// loading again releases the extract folder of the previous load
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	_, err = os.Stat(first)
+	assert.True(t, os.IsNotExist(err))
+	second := ar.layers[0].path
+	assert.NotEqual(t, first, second)
+
+	// a failed load keeps the techniques that were loaded before
+	ar.OverlayFolders = []string{filepath.Join(dir, "missing")}
+	_, err = ar.LoadTechniques()
+	assert.Error(t, err)
+	require.Equal(t, 1, len(ar.layers))
+	assert.Equal(t, second, ar.layers[0].path)
+
+	// layers that fail to load once they are open are released as well
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	ar.OverlayFolders = nil
+	ar.StrictLoad = true
+	_, err = ar.LoadTechniques()
+	assert.Error(t, err)
+	require.Equal(t, 1, len(ar.layers))
+	assert.Equal(t, second, ar.layers[0].path)
+	assert.NotEmpty(t, ar.GetAllTechniques())
+	left, err := ioutil.ReadDir(tmp)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+	require.NoError(t, ar.Close())
+	_, err = os.Stat(second)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadTechniques_FS(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for name, content := range testdataFiles(t) {
+		fsys["atomics/"+name] = &fstest.MapFile{Data: content}
+	}
+	dir, err := ioutil.TempDir("", "go-atomic-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ar := &Runner{AtomicsFS: fsys, ExtractFolder: dir}
+	_, err = ar.LoadTechniques()
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(ar.GetAllTechniques()))
+	tech, err := ar.GetTechnique("T9998")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(tech.Path, dir))
+
+	require.NoError(t, ar.extractAtomics(tech.AtomicTests[0]))
+	require.NoError(t, ar.Close())
+	// folders supplied by the user are not removed
+	_, err = os.Stat(filepath.Join(dir, "T9998", "T9998.yaml"))
+	assert.NoError(t, err)
+}
+
+func TestAtomicsRoot(t *testing.T) {
+	assert.Equal(t, ".", atomicsRoot([]string{"T1003", "T1003/T1003.yaml"}))
+	assert.Equal(t, "atomics", atomicsRoot([]string{"atomics/T1003/T1003.yaml"}))
+	assert.Equal(t, "art/atomics", atomicsRoot([]string{
+		"art", "art/atomics", "art/atomics/T1003/atomics/T1003.yaml"}))
+}
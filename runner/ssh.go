@@ -0,0 +1,341 @@
+package runner
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHTarget runs the commands of tests on a linux host over ssh. The folders of the
+// techniques a test uses are copied to Folder/atomics, which PathToAtomicsFolder resolves
+// to, and the commands run in Folder. The remote host needs a POSIX shell, uname, tar and
+// kill.
+//
+// Tests are checked against the platform of the remote host and their launchers are looked
+// up in its PATH, the executors do not have to be available on the host of the runner.
+// Targets are created with NewSSHTarget.
+type SSHTarget struct {
+	Client *ssh.Client
+	// Folder is an absolute path on the remote host.
+	Folder string
+	// remotePlatform is the platform of the remote host, like linux.
+	remotePlatform string
+	// copied are the technique folders already copied by the folder they were copied from,
+	// copyLock serializes the copies of tests run in parallel.
+	copyLock sync.Mutex
+	copied   map[string]bool
+}
+
+// sshPlatforms are the platforms of atomic red team by the name uname gives the kernel.
+var sshPlatforms = map[string]string{
+	"Linux":  linux,
+	"Darwin": macos,
+}
+
+// NewSSHTarget returns a target for the host client is connected to. The folder of the
+// target is created when needed, it defaults to go-atomic in the home folder of the user.
+func NewSSHTarget(client *ssh.Client, folder string) (*SSHTarget, error) {
+	t := &SSHTarget{Client: client, Folder: folder}
+	kernel, err := t.output("uname -s")
+	if err != nil {
+		return nil, fmt.Errorf("unable to find platform: %s", err)
+	}
+	kernel = strings.TrimSpace(kernel)
+	if t.remotePlatform = sshPlatforms[kernel]; t.remotePlatform == "" {
+		t.remotePlatform = strings.ToLower(kernel)
+	}
+	if t.Folder == "" {
+		home, err := t.output(`printf %s "$HOME"`)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find home folder: %s", err)
+		}
+		t.Folder = path.Join(home, "go-atomic")
+	}
+	if !path.IsAbs(t.Folder) {
+		return nil, fmt.Errorf("folder %q is not an absolute path", t.Folder)
+	}
+	if _, err := t.output("mkdir -p " + shellQuote(path.Join(t.Folder, "atomics"))); err != nil {
+		return nil, fmt.Errorf("unable to create folder: %s", err)
+	}
+	return t, nil
+}
+
+func (t *SSHTarget) String() string {
+	return t.Client.User() + "@" + t.Client.RemoteAddr().String()
+}
+
+func (t *SSHTarget) platform() string {
+	return t.remotePlatform
+}
+
+// lookPath finds program with command -v in a shell of the remote host.
+func (t *SSHTarget) lookPath(program string) (string, error) {
+	out, err := t.output("command -v " + shellQuote(program))
+	if out = strings.TrimSpace(out); err != nil || !path.IsAbs(out) {
+		return "", fmt.Errorf("%s was not found in PATH on %s", program, t)
+	}
+	return out, nil
+}
+
+func (t *SSHTarget) folders() (string, string) {
+	return t.Folder, path.Join(t.Folder, "atomics")
+}
+
+// output runs a command on the remote host and returns its stdout.
+func (t *SSHTarget) output(command string) (string, error) {
+	session, err := t.Client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	var stderr strings.Builder
+	session.Stderr = &stderr
+	out, err := session.Output(command)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// copyPayloads sends the folders of the techniques as a tar archive to tar on the remote
+// host. Techniques without a folder are skipped, and so are the ones copied for an earlier
+// test so that tests running at the same time do not see their payloads rewritten.
+func (t *SSHTarget) copyPayloads(techniques []string, atomicsFolder string) error {
+	t.copyLock.Lock()
+	defer t.copyLock.Unlock()
+	var pending []string
+	for _, technique := range techniques {
+		if !t.copied[filepath.Join(atomicsFolder, technique)] {
+			pending = append(pending, technique)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := t.sendPayloads(pending, atomicsFolder); err != nil {
+		return err
+	}
+	if t.copied == nil {
+		t.copied = make(map[string]bool)
+	}
+	for _, technique := range pending {
+		t.copied[filepath.Join(atomicsFolder, technique)] = true
+	}
+	return nil
+}
+
+// sendPayloads copies the folders of the techniques with a tar archive.
+func (t *SSHTarget) sendPayloads(techniques []string, atomicsFolder string) error {
+	session, err := t.Client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	session.Stderr = &stderr
+	_, atomics := t.folders()
+	if err := session.Start("tar -xf - -C " + shellQuote(atomics)); err != nil {
+		return err
+	}
+	archive := tar.NewWriter(stdin)
+	var writeErr error
+	for _, technique := range techniques {
+		folder := filepath.Join(atomicsFolder, technique)
+		if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+			continue
+		}
+		if writeErr = writeTar(archive, folder, technique); writeErr != nil {
+			break
+		}
+	}
+	if writeErr == nil {
+		writeErr = archive.Close()
+	}
+	_ = stdin.Close()
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("unable to copy payloads: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if writeErr != nil {
+		return fmt.Errorf("unable to copy payloads: %s", writeErr)
+	}
+	return nil
+}
+
+// writeTar adds the contents of folder to archive under name.
+func writeTar(archive *tar.Writer, folder, name string) error {
+	return filepath.Walk(folder, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(folder, file)
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(name, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(archive, f)
+		return err
+	})
+}
+
+// start runs the launcher in a new session. The ssh server starts every command in a new
+// session, and so a new process group, whose id is printed by the shell before it is
+// replaced by the launcher.
+func (t *SSHTarget) start(launcher []string, lc launchConfig) (launcherProcess, error) {
+	if lc.identity != nil || lc.env != nil || lc.dir != "" || lc.limits != nil || lc.cgroup != nil || lc.isolation != nil {
+		return nil, fmt.Errorf("accounts, environments, limits, cgroups and isolation are not supported on %s", t)
+	}
+	session, err := t.Client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	sp := &sshProcess{target: t, session: session}
+	if sp.stdin, err = session.StdinPipe(); err == nil {
+		var stdout, stderr io.Reader
+		if stdout, err = session.StdoutPipe(); err == nil {
+			sp.stdout = bufio.NewReader(stdout)
+			if stderr, err = session.StderrPipe(); err == nil {
+				sp.stderr = stderr
+			}
+		}
+	}
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+
+	// the launcher was resolved on the remote host when the test was built
+	quoted := make([]string, len(launcher))
+	for i, arg := range launcher {
+		quoted[i] = shellQuote(arg)
+	}
+	command := fmt.Sprintf("echo $$ && cd %s && exec %s", shellQuote(t.Folder), strings.Join(quoted, " "))
+	if err := session.Start(command); err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	line, err := sp.stdout.ReadString('\n')
+	if err == nil {
+		sp.processID, err = strconv.Atoi(strings.TrimSpace(line))
+	}
+	if err != nil {
+		_ = session.Close()
+		return nil, fmt.Errorf("unable to start launcher on %s: %s", t, err)
+	}
+	return sp, nil
+}
+
+// sshProcess is a launcher started on an SSHTarget.
+type sshProcess struct {
+	target    *SSHTarget
+	session   *ssh.Session
+	processID int
+	stdin     io.WriteCloser
+	stdout    *bufio.Reader
+	stderr    io.Reader
+	closeOnce sync.Once
+}
+
+func (sp *sshProcess) pid() int {
+	return sp.processID
+}
+
+func (sp *sshProcess) pipes() (io.WriteCloser, io.Reader, io.Reader) {
+	return sp.stdin, sp.stdout, sp.stderr
+}
+
+func (sp *sshProcess) wait() (exitStatus, error) {
+	err := sp.session.Wait()
+	var status exitStatus
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		status.code = exitErr.ExitStatus()
+		err = fmt.Errorf("exit status %d", status.code)
+		if exitErr.Signal() != "" {
+			status.code = -1
+			status.signal = "SIG" + exitErr.Signal()
+			err = fmt.Errorf("signal: %s", status.signal)
+		}
+	}
+	return status, err
+}
+
+// terminate sends the signal of the policy to the process group of the launcher from
+// another session, and kills the processes still running after the grace period. Like on
+// the host of the runner, the group gets the whole grace period even when the launcher
+// exits right away.
+func (sp *sshProcess) terminate(tp terminationPolicy, exited <-chan struct{}) {
+	if tp.signal != 0 && tp.signal != syscall.SIGKILL {
+		_, _ = sp.target.output(fmt.Sprintf("kill -%d -%d", int(tp.signal), sp.processID))
+		sp.waitForGroup(tp.gracePeriod)
+	}
+	_, _ = sp.target.output(fmt.Sprintf("kill -%d -%d", int(syscall.SIGKILL), sp.processID))
+}
+
+// remotePollInterval is how often the process group of a remote launcher is checked while
+// it gets to exit, every check runs a command in a new session.
+const remotePollInterval = 100 * time.Millisecond
+
+// waitForGroup waits until no process is left in the process group of the launcher on the
+// remote host or the timeout expired.
+func (sp *sshProcess) waitForGroup(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := sp.target.output(fmt.Sprintf("kill -0 -%d", sp.processID)); err != nil {
+			return
+		}
+		wait := time.Until(deadline)
+		if wait > remotePollInterval {
+			wait = remotePollInterval
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (sp *sshProcess) closeOutput() {
+	sp.closeOnce.Do(func() {
+		_ = sp.session.Close()
+	})
+}
+
+// shellQuote quotes a word for a POSIX shell.
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
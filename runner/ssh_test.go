@@ -0,0 +1,274 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ejohn/go-atomic/art"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// startSSHServer starts an ssh server that runs the commands of exec requests with sh in
+// a new session, like sshd, and with home as HOME and home/bin first in PATH. It returns a
+// client connected to it.
+func startSSHServer(t *testing.T, home string) *ssh.Client {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config, home)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "atomic",
+		HostKeyCallback: ssh.FixedHostKey(signer.PublicKey()),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, home string) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests, home)
+	}
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request, home string) {
+	defer channel.Close()
+	for req := range requests {
+		var payload struct{ Command string }
+		if req.Type != "exec" || ssh.Unmarshal(req.Payload, &payload) != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+		cmd := exec.Command("sh", "-c", payload.Command)
+		cmd.Dir = home
+		cmd.Env = append(os.Environ(), "HOME="+home, "PATH="+filepath.Join(home, "bin")+":"+os.Getenv("PATH"))
+		cmd.Stdout, cmd.Stderr = channel, channel.Stderr()
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		stdin, err := cmd.StdinPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{127}))
+			return
+		}
+		go func() {
+			_, _ = io.Copy(stdin, channel)
+			_ = stdin.Close()
+		}()
+		_ = cmd.Wait()
+		ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		if ws.Signaled() {
+			_, _ = channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}{Signal: strings.TrimPrefix(unix.SignalName(ws.Signal()), "SIG")}))
+		} else {
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(ws.ExitStatus())}))
+		}
+		return
+	}
+}
+
+func TestRunTest_SSHTarget(t *testing.T) {
+	home := t.TempDir()
+	target, err := NewSSHTarget(startSSHServer(t, home), "")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "go-atomic"), target.Folder)
+
+	atomicsFolder := t.TempDir()
+	for technique, payload := range map[string]string{"T9999": "test", "T1111": "argument", "T2222": "unused"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(atomicsFolder, technique, "src"), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(atomicsFolder, technique, "src", "payload.txt"), []byte(payload), 0644))
+	}
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		AtomicsFolder:      atomicsFolder,
+		SupportedPlatforms: []string{getCurrentPlatform()},
+		InputArguments: map[string]art.Argument{
+			"payload": {Type: "path", Default: "PathToAtomicsFolder/T1111/src/payload.txt"},
+		},
+		Executor: art.Executor{
+			Name:    "sh",
+			Command: "cat PathToAtomicsFolder/T9999/src/payload.txt #{payload}\npwd\nexit 3",
+		},
+	}
+	ar := Runner{}
+	rc := getDefaultRC()
+	rc.Target = target
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	require.Error(t, err)
+	assert.Equal(t, target.String(), out.Target)
+	require.Equal(t, 1, len(out.AtomicTest))
+	res := out.AtomicTest[0].Result
+	assert.Equal(t, "testargument"+target.Folder+"\n", res.Stdout)
+	assert.Equal(t, 3, res.ExitCode)
+
+	_, err = os.Stat(filepath.Join(target.Folder, "atomics", "T2222"))
+	assert.True(t, os.IsNotExist(err), "payloads of other techniques are not copied")
+
+	// payloads are copied once per target, parallel runs do not rewrite them
+	require.NoError(t, ioutil.WriteFile(filepath.Join(atomicsFolder, "T9999", "src", "payload.txt"), []byte("changed"), 0644))
+	var runs []TestRun
+	for i := 0; i < 4; i++ {
+		test := atomicTest
+		runs = append(runs, TestRun{Test: &test})
+	}
+	for _, result := range ar.RunTests(context.Background(), runs, rc, 4) {
+		require.Equal(t, 1, len(result.Info.AtomicTest))
+		assert.Equal(t, "testargument"+target.Folder+"\n", result.Info.AtomicTest[0].Result.Stdout)
+	}
+
+	rc.Sandbox = true
+	_, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	assert.EqualError(t, err, "sandbox not supported on target "+target.String())
+}
+
+func TestRunTest_SSHTargetExecutors(t *testing.T) {
+	home := t.TempDir()
+	bin := filepath.Join(home, "bin")
+	require.NoError(t, os.MkdirAll(bin, 0755))
+	for name, script := range map[string]string{
+		"pwsh":          "exec sh",
+		"go-atomic-tcl": "exec sh",
+		"uname":         "echo Darwin",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	}
+	target, err := NewSSHTarget(startSSHServer(t, home), "")
+	require.NoError(t, err)
+
+	// the platform and the launchers come from the remote host, none of them exist here
+	atomicTest := art.Test{
+		TechniqueID:        "T9999",
+		Name:               "Test",
+		SupportedPlatforms: []string{linux},
+		Executor:           art.Executor{Name: "pwsh", Command: "echo remote"},
+	}
+	ar := Runner{}
+	ar.RegisterExecutor("tcl", launcherFactory("/opt/go-atomic/go-atomic-tcl"))
+	rc := getDefaultRC()
+	rc.Target = target
+	out, err := ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	assert.EqualError(t, err, `"Test" is not a valid test for macos`)
+	assert.Equal(t, macos, out.Platform)
+
+	atomicTest.SupportedPlatforms = []string{macos}
+	for executor, launcher := range map[string][]string{
+		"pwsh": {filepath.Join(bin, "pwsh"), "-Command", "-"},
+		"tcl":  {filepath.Join(bin, "go-atomic-tcl")},
+	} {
+		atomicTest.Executor.Name = executor
+		out, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+		require.NoError(t, err, executor)
+		require.Equal(t, 1, len(out.AtomicTest))
+		assert.Equal(t, "remote\n", out.AtomicTest[0].Result.Stdout)
+		bt, err := ar.buildTest(&atomicTest, nil, target.Folder, target)
+		require.NoError(t, err)
+		assert.Equal(t, launcher, bt.Launcher)
+	}
+
+	atomicTest.Executor.Name = "zsh"
+	_, err = ar.RunTest(context.Background(), &atomicTest, nil, rc)
+	assert.EqualError(t, err, "error getting launcher: zsh was not found in PATH on "+target.String())
+}
+
+func TestRunCommands_SSHTargetTimeout(t *testing.T) {
+	target, err := NewSSHTarget(startSSHServer(t, t.TempDir()), "")
+	require.NoError(t, err)
+	launcher, err := getLauncher("sh")
+	require.NoError(t, err)
+
+	// the process left in the background holds the output of the session open until the
+	// process group of the launcher is killed
+	out, err := runCommands(context.Background(), launcher, "sleep 301 &\necho $!\nsleep 302", commandConfig{
+		target:      target,
+		timeout:     200 * time.Millisecond,
+		termination: terminationPolicy{signal: syscall.SIGTERM, gracePeriod: 100 * time.Millisecond},
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, len(out))
+	assert.True(t, out[0].Result.Killed)
+	assert.Equal(t, -1, out[0].Result.ExitCode)
+	assert.Equal(t, "SIGTERM", out[0].Result.Signal)
+	pid, err := strconv.Atoi(strings.TrimSpace(out[0].Result.Stdout))
+	require.NoError(t, err, out[0].Result.Stdout)
+	assert.Eventually(t, func() bool { return !processRunning(pid) }, 5*time.Second, 10*time.Millisecond)
+
+	// the processes started by the launcher get the grace period even when it exits right away
+	// and the session ends with it
+	out, err = runCommands(context.Background(), launcher,
+		"(trap 'sleep 0.2; echo flushed > flushed.txt; exit 0' TERM; sleep 5 & wait) > /dev/null 2>&1 &\n"+
+			"trap 'exit 3' TERM\nwait", commandConfig{
+			target:      target,
+			timeout:     200 * time.Millisecond,
+			termination: terminationPolicy{signal: syscall.SIGTERM, gracePeriod: 2 * time.Second},
+		})
+	require.Error(t, err)
+	require.Equal(t, 1, len(out))
+	assert.True(t, out[0].Result.Killed)
+	assert.Eventually(t, func() bool {
+		flushed, err := ioutil.ReadFile(filepath.Join(target.Folder, "flushed.txt"))
+		return err == nil && string(flushed) == "flushed\n"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// processRunning returns whether pid is a process that has not exited, zombies have.
+func processRunning(pid int) bool {
+	out, err := exec.Command("ps", "-o", "stat=", "-p", strconv.Itoa(pid)).Output()
+	state := bytes.TrimSpace(out)
+	return err == nil && len(state) > 0 && state[0] != 'Z'
+}
@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/ejohn/go-atomic/art"
+)
+
+// Target is the host the commands of a test are run on. The commands run on the host of
+// the runner when TestRunConfig.Target is nil, like with LocalTarget. SSHTarget runs them
+// on another host. The launcher of a test is resolved and started on the target and the
+// commands are written to its stdin.
+type Target interface {
+	// String identifies the target in the results, like user@host:22.
+	String() string
+	// platform is the platform of the target in the terms of atomic red team, like macos.
+	platform() string
+	// lookPath finds program in the PATH of the target.
+	lookPath(program string) (string, error)
+	// start starts a launcher on the target.
+	start(launcher []string, lc launchConfig) (launcherProcess, error)
+	// folders returns the working directory of the commands on the target and the folder
+	// PathToAtomicsFolder resolves to there. They are empty when the folders of the runner
+	// are used.
+	folders() (workdir, atomicsFolder string)
+	// copyPayloads copies the folders of techniques from atomicsFolder, the folder the test
+	// was loaded from, to the atomics folder of the target.
+	copyPayloads(techniques []string, atomicsFolder string) error
+}
+
+// launcherProcess is a launcher started on a target.
+type launcherProcess interface {
+	// pid is the process id of the launcher on its target.
+	pid() int
+	// pipes returns the stdin of the launcher, its stdout and its stderr.
+	pipes() (io.WriteCloser, io.Reader, io.Reader)
+	// wait waits for the launcher to exit. The error is not nil when it did not exit with 0.
+	wait() (exitStatus, error)
+	// terminate stops the launcher and the processes it started with tp. exited is closed
+	// once wait returned.
+	terminate(tp terminationPolicy, exited <-chan struct{})
+	// closeOutput closes stdout and stderr, reads from them return once they are closed.
+	closeOutput()
+}
+
+// exitStatus is how a launcher exited. signal is the name of the signal that ended it, in
+// which case code is -1.
+type exitStatus struct {
+	code   int
+	signal string
+}
+
+// LocalTarget runs the commands on the host of the runner.
+type LocalTarget struct{}
+
+func (LocalTarget) String() string {
+	return "local"
+}
+
+func (LocalTarget) platform() string {
+	return getCurrentPlatform()
+}
+
+func (LocalTarget) lookPath(program string) (string, error) {
+	path, err := exec.LookPath(program)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("%s was not found in PATH", program)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to find %s: %s", program, err)
+	}
+	return path, nil
+}
+
+func (LocalTarget) start(launcher []string, lc launchConfig) (launcherProcess, error) {
+	return startLauncher(launcher, lc)
+}
+
+func (LocalTarget) folders() (string, string) {
+	return "", ""
+}
+
+func (LocalTarget) copyPayloads(techniques []string, atomicsFolder string) error {
+	return nil
+}
+
+func (lp *launchProc) pid() int {
+	return lp.cmd.Process.Pid
+}
+
+func (lp *launchProc) pipes() (io.WriteCloser, io.Reader, io.Reader) {
+	return lp.stdin, lp.stdout, lp.stderr
+}
+
+func (lp *launchProc) wait() (exitStatus, error) {
+	err := lp.cmd.Wait()
+	var status exitStatus
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			status.code = ws.ExitStatus()
+			if ws.Signaled() {
+				status.signal = signalName(ws.Signal())
+			}
+		}
+	}
+	return status, err
+}
+
+func (lp *launchProc) terminate(tp terminationPolicy, exited <-chan struct{}) {
+	terminateLauncher(lp, tp, exited)
+}
+
+func (lp *launchProc) closeOutput() {
+	closeFiles(lp.stdout, lp.stderr)
+}
+
+// isLocal returns whether the commands of a test run on the host of the runner.
+func isLocal(target Target) bool {
+	_, local := target.(LocalTarget)
+	return target == nil || local
+}
+
+// targetOf returns target, or LocalTarget when it is nil.
+func targetOf(target Target) Target {
+	if target == nil {
+		return LocalTarget{}
+	}
+	return target
+}
+
+// payloadPattern matches the techniques whose folder is used by a command, like
+// PathToAtomicsFolder/T1003/bin or $PathToAtomicsFolder\T1055.001\src.
+var payloadPattern = regexp.MustCompile(`PathToAtomicsFolder[\\/]+(T\d{4}(?:\.\d{3})?)\b`)
+
+// payloadTechniques returns the techniques whose folders hold the payloads of a test, its
+// own technique and the ones its commands and arguments refer to.
+func payloadTechniques(atomicTest *art.Test, arguments map[string]string) []string {
+	texts := []string{atomicTest.Executor.Command, atomicTest.Executor.CleanupCommand, atomicTest.Executor.Steps}
+	for _, arg := range atomicTest.InputArguments {
+		texts = append(texts, arg.Default)
+	}
+	for _, value := range arguments {
+		texts = append(texts, value)
+	}
+	for _, dep := range atomicTest.Dependencies {
+		texts = append(texts, dep.PrereqCommand, dep.GetPrereqCommand)
+	}
+	techniques := map[string]bool{atomicTest.TechniqueID: true}
+	for _, text := range texts {
+		for _, match := range payloadPattern.FindAllStringSubmatch(text, -1) {
+			techniques[strings.ToUpper(match[1])] = true
+		}
+	}
+	var ids []string
+	for id := range techniques {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}